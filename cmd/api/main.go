@@ -8,12 +8,18 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/outbox"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/auth"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/signedurl"
 	"github.com/mahdi-vajdi/go-image-processor/internal/platform/validator"
 	"github.com/mahdi-vajdi/go-image-processor/internal/processing"
 	"github.com/mahdi-vajdi/go-image-processor/internal/repository/postgres"
 	"github.com/mahdi-vajdi/go-image-processor/internal/router"
+	"github.com/mahdi-vajdi/go-image-processor/internal/webhook"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -78,20 +84,128 @@ func main() {
 	}
 
 	// Processing service
+	priorityWeights := make(map[model.TaskPriority]int, len(cfg.ProcessingService.PriorityWeights))
+	for name, weight := range cfg.ProcessingService.PriorityWeights {
+		priority, err := model.ParsePriority(name)
+		if err != nil {
+			log.Fatalf("Invalid priority weights: %v", err)
+		}
+		priorityWeights[priority] = weight
+	}
+
 	processingService := processing.NewService(repo, imageStore, processing.ServiceConfig{
-		WorkerPoolSize:  cfg.ProcessingService.WorkerPoolSize,
-		PollingInterval: cfg.ProcessingService.PollingInterval,
-		TaskBatchSize:   cfg.ProcessingService.TaskBatchSize,
+		WorkerPoolSize:       cfg.ProcessingService.WorkerPoolSize,
+		WorkerPoolMin:        cfg.ProcessingService.WorkerPoolMin,
+		WorkerPoolMax:        cfg.ProcessingService.WorkerPoolMax,
+		ScaleInterval:        cfg.ProcessingService.ScaleInterval,
+		ScaleDownDelay:       cfg.ProcessingService.ScaleDownDelay,
+		PollingInterval:      cfg.ProcessingService.PollingInterval,
+		TaskBatchSize:        cfg.ProcessingService.TaskBatchSize,
+		InstanceID:           cfg.ProcessingService.InstanceID,
+		LeaseDuration:        cfg.ProcessingService.LeaseDuration,
+		ReaperInterval:       cfg.ProcessingService.ReaperInterval,
+		MaxAttempts:          cfg.ProcessingService.MaxAttempts,
+		RetryBaseDelay:       cfg.ProcessingService.RetryBaseDelay,
+		RetryMaxDelay:        cfg.ProcessingService.RetryMaxDelay,
+		PriorityWeights:      priorityWeights,
+		MaxImagePixels:       int64(cfg.ProcessingService.MaxImagePixels),
+		MaxImageDimension:    cfg.ProcessingService.MaxImageDimension,
+		MaxDecodeMemory:      int64(cfg.ProcessingService.MaxDecodeMemory),
+		TransformCacheMemory: int64(cfg.ProcessingService.TransformCacheMemory),
+		DerivedTTL:           cfg.ProcessingService.DerivedTTL,
+		MinSSIM:              cfg.ProcessingService.MinSSIM,
 	})
 	processingService.Start()
 
+	// Webhooks
+	// Receivers can't tell an unsigned payload from a forged one, so every payload is signed
+	if strings.TrimSpace(cfg.Webhook.Secret) == "" {
+		log.Fatalf("WEBHOOK_SECRET is required, the webhook payloads are signed with it")
+	}
+	webhookDispatcher := webhook.NewDispatcher(repo, webhook.DispatcherConfig{
+		Secret:               cfg.Webhook.Secret,
+		PollingInterval:      cfg.Webhook.PollingInterval,
+		BatchSize:            cfg.Webhook.BatchSize,
+		Timeout:              cfg.Webhook.Timeout,
+		MaxAttempts:          cfg.Webhook.MaxAttempts,
+		RetryBaseDelay:       cfg.Webhook.RetryBaseDelay,
+		RetryMaxDelay:        cfg.Webhook.RetryMaxDelay,
+		AllowPrivateNetworks: cfg.Webhook.AllowPrivateNetworks,
+	})
+	webhookDispatcher.Start()
+
+	// Outbox
+	var eventPublisher outbox.EventPublisher
+	if cfg.Outbox.Publisher == "postgres" {
+		eventPublisher = outbox.NewNotifyPublisher(db, cfg.Outbox.NotifyChannel)
+	} else if cfg.Outbox.Publisher == "memory" {
+		// Nothing in the API subscribes to a MemoryPublisher, the events would never leave the outbox
+		log.Fatalf("Outbox publisher memory is only for embedding the relay with an in-process consumer, use postgres")
+	} else {
+		log.Fatalf("Unknown outbox publisher: %s", cfg.Outbox.Publisher)
+	}
+
+	outboxRelay := outbox.NewRelay(repo, eventPublisher, outbox.RelayConfig{
+		PollingInterval: cfg.Outbox.PollingInterval,
+		BatchSize:       cfg.Outbox.BatchSize,
+		Retention:       cfg.Outbox.Retention,
+	})
+	outboxRelay.Start()
+
 	// Validator
 	appValidator := validator.New()
 
+	// Signed URLs
+	var urlSigner *signedurl.Signer
+	if cfg.SignedURL.Disabled {
+		log.Println("Warning: URL_SIGNING_DISABLED is set, images are served without signed URLs")
+	} else {
+		signingKeys, err := signedurl.ParseKeys(nonEmpty(cfg.SignedURL.Keys))
+		if err != nil {
+			log.Fatalf("Invalid URL_SIGNING_KEYS: %v", err)
+		}
+		if len(signingKeys) == 0 {
+			log.Fatalf("URL_SIGNING_KEYS is required, set URL_SIGNING_DISABLED=true to serve the images without signed URLs")
+		}
+		urlSigner, err = signedurl.NewSigner(signingKeys, cfg.SignedURL.TTL, cfg.SignedURL.MaxTTL)
+		if err != nil {
+			log.Fatalf("Failed to create the URL signer: %v", err)
+		}
+	}
+
+	// Downloads
+	redirectDownloads := false
+	switch cfg.Storage.DownloadMode {
+	case "stream":
+	case "redirect":
+		if _, ok := imageStore.(storage.Presigner); ok {
+			redirectDownloads = true
+		} else {
+			log.Printf("Warning: %s storage can't presign URLs, images are streamed", cfg.Storage.Type)
+		}
+	default:
+		log.Fatalf("Unknown storage download mode: %s", cfg.Storage.DownloadMode)
+	}
+
 	// Handler
-	apiHandler := handler.NewHandler(repo, imageStore, processingService, appValidator)
+	apiHandler := handler.NewHandler(repo, imageStore, processingService, appValidator, urlSigner, handler.Config{
+		RedirectDownloads: redirectDownloads,
+		PresignExpiry:     cfg.Storage.PresignExpiry,
+	})
 
-	r := router.New(apiHandler)
+	// Admin API
+	adminKeys := nonEmpty(cfg.Admin.Keys)
+	if len(adminKeys) == 0 {
+		log.Println("Warning: ADMIN_API_KEYS not set, the admin API is disabled")
+	}
+
+	// Clients
+	apiKeys, err := auth.ParseKeys(nonEmpty(cfg.API.Keys))
+	if err != nil {
+		log.Fatalf("Invalid API_KEYS: %v", err)
+	}
+
+	r := router.New(apiHandler, urlSigner, apiKeys, adminKeys)
 
 	// Server
 	serverAddr := fmt.Sprintf("%s:%d", cfg.HTTP.Host, cfg.HTTP.Port)
@@ -133,5 +247,23 @@ func main() {
 	processingService.Stop(shutdownCtx)
 	log.Println("Image processing service stopped.")
 
+	// Stopped last so the tasks finished during the shutdown are notified
+	log.Println("Shutting down webhook dispatcher...")
+	webhookDispatcher.Stop(shutdownCtx)
+
+	log.Println("Shutting down outbox relay...")
+	outboxRelay.Stop(shutdownCtx)
+
 	log.Println("Application shutdown complete.")
 }
+
+// nonEmpty returns the trimmed values without the empty ones, so a list variable that is set but empty has no values
+func nonEmpty(values []string) []string {
+	var trimmed []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			trimmed = append(trimmed, value)
+		}
+	}
+	return trimmed
+}
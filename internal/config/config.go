@@ -14,6 +14,11 @@ type Config struct {
 	Database          DatabaseConfig
 	Storage           StorageConfig
 	ProcessingService ProcessingServiceConfig
+	Webhook           WebhookConfig
+	Outbox            OutboxConfig
+	SignedURL         SignedURLConfig
+	Admin             AdminConfig
+	API               APIConfig
 }
 
 type AppConfig struct {
@@ -37,6 +42,9 @@ type StorageConfig struct {
 	Type  string
 	Local LocalStorageConfig
 	S3    S3StorageConfig
+	// DownloadMode is either stream (through the API) or redirect (to a presigned URL, for storages that support it)
+	DownloadMode  string
+	PresignExpiry time.Duration
 }
 
 type LocalStorageConfig struct {
@@ -54,8 +62,69 @@ type S3StorageConfig struct {
 
 type ProcessingServiceConfig struct {
 	WorkerPoolSize  int
+	WorkerPoolMin   int
+	WorkerPoolMax   int
+	ScaleInterval   time.Duration
+	ScaleDownDelay  time.Duration
 	PollingInterval time.Duration
 	TaskBatchSize   int
+	InstanceID      string
+	LeaseDuration   time.Duration
+	ReaperInterval  time.Duration
+	MaxAttempts     int
+	RetryBaseDelay  time.Duration
+	RetryMaxDelay   time.Duration
+	PriorityWeights map[string]int
+	// Limits for the images being decoded, MaxDecodeMemory is in bytes
+	MaxImagePixels    int
+	MaxImageDimension int
+	MaxDecodeMemory   int
+	// TransformCacheMemory is the size in bytes of the in-memory cache of on-demand transformations
+	TransformCacheMemory int
+	// DerivedTTL is how long the stored outputs of on-demand transformations are kept
+	DerivedTTL time.Duration
+	// MinSSIM is the default SSIM target of the automatic quality, 0 disables it
+	MinSSIM float64
+}
+
+type WebhookConfig struct {
+	Secret          string
+	PollingInterval time.Duration
+	BatchSize       int
+	Timeout         time.Duration
+	MaxAttempts     int
+	RetryBaseDelay  time.Duration
+	RetryMaxDelay   time.Duration
+	// AllowPrivateNetworks lets callback URLs reach loopback, private and link-local addresses, for development
+	AllowPrivateNetworks bool
+}
+
+type OutboxConfig struct {
+	// Publisher is either postgres (LISTEN/NOTIFY) or memory
+	Publisher       string
+	NotifyChannel   string
+	PollingInterval time.Duration
+	BatchSize       int
+	Retention       time.Duration
+}
+
+type AdminConfig struct {
+	// Keys are the bearer tokens of the admin API, it's disabled without keys
+	Keys []string
+}
+
+type APIConfig struct {
+	// Keys are owner:key pairs, the bearer tokens of the clients of the image API
+	Keys []string
+}
+
+type SignedURLConfig struct {
+	// Keys are id:secret pairs, the first one signs and all of them verify. They're required unless Disabled is set.
+	Keys   []string
+	TTL    time.Duration
+	MaxTTL time.Duration
+	// Disabled serves the images without signed URLs, only for development
+	Disabled bool
 }
 
 func LoadConfig() (*Config, error) {
@@ -76,7 +145,9 @@ func LoadConfig() (*Config, error) {
 				"user=root password=root host=localhost port=5432 dbname=go_image_processor sslmode=disable"),
 		},
 		Storage: StorageConfig{
-			Type: getEnv("STORAGE_TYPE", "local"),
+			Type:          getEnv("STORAGE_TYPE", "local"),
+			DownloadMode:  getEnv("STORAGE_DOWNLOAD_MODE", "stream"),
+			PresignExpiry: getEnvAsDuration("STORAGE_PRESIGN_EXPIRY", 5*time.Minute),
 			Local: LocalStorageConfig{
 				BaseDir: getEnv("LOCAL_STORAGE_DIR", "./data/"),
 			},
@@ -90,9 +161,55 @@ func LoadConfig() (*Config, error) {
 			},
 		},
 		ProcessingService: ProcessingServiceConfig{
-			WorkerPoolSize:  getEnvAsInt("PROCESSING_WORKER_POOL_SIZE", 5),
-			PollingInterval: getEnvAsDuration("PROCESSING_POLLING_INTERVAL", 5*time.Second),
-			TaskBatchSize:   getEnvAsInt("PROCESSING_TASK_BATCH_SIZE", 10),
+			WorkerPoolSize:       getEnvAsInt("PROCESSING_WORKER_POOL_SIZE", 5),
+			WorkerPoolMin:        getEnvAsInt("PROCESSING_WORKER_POOL_MIN", 0),
+			WorkerPoolMax:        getEnvAsInt("PROCESSING_WORKER_POOL_MAX", 0),
+			ScaleInterval:        getEnvAsDuration("PROCESSING_SCALE_INTERVAL", 5*time.Second),
+			ScaleDownDelay:       getEnvAsDuration("PROCESSING_SCALE_DOWN_DELAY", time.Minute),
+			PollingInterval:      getEnvAsDuration("PROCESSING_POLLING_INTERVAL", 5*time.Second),
+			TaskBatchSize:        getEnvAsInt("PROCESSING_TASK_BATCH_SIZE", 10),
+			InstanceID:           getEnv("PROCESSING_INSTANCE_ID", ""),
+			LeaseDuration:        getEnvAsDuration("PROCESSING_LEASE_DURATION", time.Minute),
+			ReaperInterval:       getEnvAsDuration("PROCESSING_REAPER_INTERVAL", 30*time.Second),
+			MaxAttempts:          getEnvAsInt("PROCESSING_MAX_ATTEMPTS", 5),
+			RetryBaseDelay:       getEnvAsDuration("PROCESSING_RETRY_BASE_DELAY", 5*time.Second),
+			RetryMaxDelay:        getEnvAsDuration("PROCESSING_RETRY_MAX_DELAY", 10*time.Minute),
+			PriorityWeights:      getEnvAsIntMap("PROCESSING_PRIORITY_WEIGHTS", map[string]int{"high": 6, "normal": 3, "low": 1}),
+			MaxImagePixels:       getEnvAsInt("PROCESSING_MAX_IMAGE_PIXELS", 50_000_000),
+			MaxImageDimension:    getEnvAsInt("PROCESSING_MAX_IMAGE_DIMENSION", 16384),
+			MaxDecodeMemory:      getEnvAsInt("PROCESSING_MAX_DECODE_MEMORY", 1<<30),
+			TransformCacheMemory: getEnvAsInt("PROCESSING_TRANSFORM_CACHE_MEMORY", 64<<20),
+			DerivedTTL:           getEnvAsDuration("PROCESSING_DERIVED_TTL", 7*24*time.Hour),
+			MinSSIM:              getEnvAsFloat("PROCESSING_MIN_SSIM", 0),
+		},
+		Webhook: WebhookConfig{
+			Secret:               getEnv("WEBHOOK_SECRET", ""),
+			AllowPrivateNetworks: getEnvAsBool("WEBHOOK_ALLOW_PRIVATE_NETWORKS", false),
+			PollingInterval:      getEnvAsDuration("WEBHOOK_POLLING_INTERVAL", 2*time.Second),
+			BatchSize:            getEnvAsInt("WEBHOOK_BATCH_SIZE", 10),
+			Timeout:              getEnvAsDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+			MaxAttempts:          getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 8),
+			RetryBaseDelay:       getEnvAsDuration("WEBHOOK_RETRY_BASE_DELAY", 10*time.Second),
+			RetryMaxDelay:        getEnvAsDuration("WEBHOOK_RETRY_MAX_DELAY", time.Hour),
+		},
+		Outbox: OutboxConfig{
+			Publisher:       getEnv("OUTBOX_PUBLISHER", "postgres"),
+			NotifyChannel:   getEnv("OUTBOX_NOTIFY_CHANNEL", "image_processor_events"),
+			PollingInterval: getEnvAsDuration("OUTBOX_POLLING_INTERVAL", time.Second),
+			BatchSize:       getEnvAsInt("OUTBOX_BATCH_SIZE", 100),
+			Retention:       getEnvAsDuration("OUTBOX_RETENTION", 7*24*time.Hour),
+		},
+		SignedURL: SignedURLConfig{
+			Keys:     getEnvAsSlice("URL_SIGNING_KEYS", nil, ","),
+			TTL:      getEnvAsDuration("URL_SIGNING_TTL", time.Hour),
+			MaxTTL:   getEnvAsDuration("URL_SIGNING_MAX_TTL", 7*24*time.Hour),
+			Disabled: getEnvAsBool("URL_SIGNING_DISABLED", false),
+		},
+		Admin: AdminConfig{
+			Keys: getEnvAsSlice("ADMIN_API_KEYS", nil, ","),
+		},
+		API: APIConfig{
+			Keys: getEnvAsSlice("API_KEYS", nil, ","),
 		},
 	}
 
@@ -116,6 +233,16 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatVal
+		}
+		fmt.Printf("Warning: Environment %s has invalid float value, using default\n", key)
+	}
+	return defaultValue
+}
+
 func getEnvAsBool(key string, defaultValue bool) bool {
 	if value, exists := os.LookupEnv(key); exists {
 		if boolVal, err := strconv.ParseBool(value); err == nil {
@@ -142,3 +269,23 @@ func getEnvAsSlice(key string, defaultValue []string, separator string) []string
 	}
 	return defaultValue
 }
+
+// getEnvAsIntMap parses a list of name:value pairs, e.g. "high:6,normal:3,low:1"
+func getEnvAsIntMap(key string, defaultValue map[string]int) map[string]int {
+	pairs := getEnvAsSlice(key, nil, ",")
+	if pairs == nil {
+		return defaultValue
+	}
+
+	result := make(map[string]int, len(pairs))
+	for _, pair := range pairs {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), ":")
+		intVal, err := strconv.Atoi(value)
+		if !found || err != nil {
+			fmt.Printf("Warning: Environment %s has invalid name:integer pair %q, using default\n", key, pair)
+			return defaultValue
+		}
+		result[name] = intVal
+	}
+	return result
+}
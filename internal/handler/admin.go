@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/auth"
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
+)
+
+type failedTasksQuery struct {
+	Statuses []string `json:"status" validate:"dive,oneof=failed dead_letter"`
+	Error    string   `json:"error" validate:"max=255"`
+	From     *time.Time
+	To       *time.Time
+	Limit    int `json:"limit" validate:"gte=1,lte=500"`
+	Offset   int `json:"offset" validate:"gte=0"`
+}
+
+type requeueTasksRequest struct {
+	IDs []int64 `json:"ids" validate:"required,min=1,max=1000"`
+}
+
+// parseFailedTasksQuery reads the filters shared by the failed task endpoints. Only failed and dead-lettered
+// tasks can be listed or purged, so those are the default statuses.
+func (h *handler) parseFailedTasksQuery(values url.Values) (*repository.TaskFilter, error) {
+	q := failedTasksQuery{
+		Statuses: values["status"],
+		Error:    values.Get("error"),
+		Limit:    50,
+	}
+	if len(q.Statuses) == 0 {
+		q.Statuses = []string{string(model.StatusFailed), string(model.StatusDeadLetter)}
+	}
+
+	var err error
+	if q.From, err = parseTimeParam(values, "from"); err != nil {
+		return nil, err
+	}
+	if q.To, err = parseTimeParam(values, "to"); err != nil {
+		return nil, err
+	}
+	if q.Limit, err = parseIntParam(values, "limit", q.Limit); err != nil {
+		return nil, err
+	}
+	if q.Offset, err = parseIntParam(values, "offset", 0); err != nil {
+		return nil, err
+	}
+
+	if err = h.validate.Validate(q); err != nil {
+		return nil, err
+	}
+
+	filter := &repository.TaskFilter{
+		ErrorContains: q.Error,
+		UpdatedAfter:  q.From,
+		UpdatedBefore: q.To,
+		Limit:         q.Limit,
+		Offset:        q.Offset,
+	}
+	for _, status := range q.Statuses {
+		filter.Statuses = append(filter.Statuses, model.TaskStatus(status))
+	}
+
+	return filter, nil
+}
+
+func (h *handler) ListFailedTasks(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	filter, err := h.parseFailedTasksQuery(r.URL.Query())
+	if err != nil {
+		queryErrorJSON(w, err)
+		return
+	}
+
+	tasks, err := h.repo.ListTasks(ctx, *filter)
+	if err != nil {
+		ErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("failed to list tasks: %v", err))
+		return
+	}
+
+	ResponseJSON(w, http.StatusOK, map[string]any{"tasks": h.newTaskResponses(tasks, auth.CallerFrom(ctx)), "count": len(tasks)})
+}
+
+func (h *handler) RequeueTasks(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var req requeueTasksRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	if err := h.validate.Validate(req); err != nil {
+		ValidationErrorJSON(w, err)
+		return
+	}
+
+	requeued, err := h.repo.RequeueTasks(ctx, req.IDs)
+	if err != nil {
+		ErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("failed to requeue tasks: %v", err))
+		return
+	}
+
+	if requeued > 0 {
+		h.processor.Wake()
+	}
+
+	ResponseJSON(w, http.StatusOK, map[string]int64{"requeued": requeued})
+}
+
+func (h *handler) PurgeTasks(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	// Purging every failed task must be asked for explicitly
+	values := r.URL.Query()
+	if !hasPurgeFilter(values) && values.Get("all") != "true" {
+		ErrorJSON(w, http.StatusBadRequest, "purging needs a status, error, from or to filter, or all=true to purge every failed task")
+		return
+	}
+
+	filter, err := h.parseFailedTasksQuery(values)
+	if err != nil {
+		queryErrorJSON(w, err)
+		return
+	}
+	// Purge everything that matches, the limit only applies to listing
+	filter.Limit = 0
+	filter.Offset = 0
+
+	purged, storageKeys, err := h.repo.PurgeTasks(ctx, *filter)
+	if err != nil {
+		ErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("failed to purge tasks: %v", err))
+		return
+	}
+
+	// The rows are gone, so the files are removed on a best effort basis
+	for _, key := range storageKeys {
+		if err = h.imageStore.Delete(context.Background(), key); err != nil {
+			log.Printf("Warning: failed to delete storage key %s of a purged task: %v", key, err)
+		}
+		if err = h.processor.RemoveDerived(context.Background(), key); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	ResponseJSON(w, http.StatusOK, map[string]int64{"purged": purged})
+}
+
+func hasPurgeFilter(values url.Values) bool {
+	for _, name := range []string{"status", "error", "from", "to"} {
+		if values.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTimeParam(values url.Values, name string) (*time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: must be an RFC 3339 time", name)
+	}
+
+	return &t, nil
+}
+
+func parseIntParam(values url.Values, name string, defaultValue int) (int, error) {
+	value := values.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: must be an integer", name)
+	}
+
+	return i, nil
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/auth"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/validator"
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
+)
+
+// fakeFailedTasksRepository lists its tasks for any filter
+type fakeFailedTasksRepository struct {
+	repository.Repository
+	tasks []model.ImageProcessingTask
+}
+
+func (r *fakeFailedTasksRepository) ListTasks(_ context.Context, _ repository.TaskFilter) ([]model.ImageProcessingTask, error) {
+	return r.tasks, nil
+}
+
+func TestListFailedTasksUsesTaskResponses(t *testing.T) {
+	lease := time.Now()
+	repo := &fakeFailedTasksRepository{tasks: []model.ImageProcessingTask{{
+		ID:             7,
+		Status:         model.StatusFailed,
+		ErrorMessage:   "image: unknown format",
+		Owner:          "acme",
+		ClaimedBy:      "instance-1",
+		LeaseExpiresAt: &lease,
+		Attempts:       3,
+	}}}
+	h := &handler{repo: repo, validate: validator.New()}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/tasks/failed", nil)
+	req = req.WithContext(auth.WithCaller(req.Context(), auth.Caller{Admin: true}))
+	rec := httptest.NewRecorder()
+	h.ListFailedTasks(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	var body struct {
+		Tasks []map[string]any `json:"tasks"`
+		Count int              `json:"count"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode the response: %v", err)
+	}
+	if body.Count != 1 || len(body.Tasks) != 1 {
+		t.Fatalf("listed %d tasks with a count of %d, want 1", len(body.Tasks), body.Count)
+	}
+
+	task := body.Tasks[0]
+	if task["id"] != float64(7) || task["status"] != "failed" || task["error_message"] != "image: unknown format" {
+		t.Errorf("task = %v, want the fields of the task response", task)
+	}
+	for _, field := range []string{"ID", "ClaimedBy", "claimed_by", "LeaseExpiresAt", "lease_expires_at"} {
+		if _, ok := task[field]; ok {
+			t.Errorf("task has the field %s of the database model", field)
+		}
+	}
+}
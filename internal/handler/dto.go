@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/auth"
+)
+
+// taskResponse is the representation of a task in the API responses. It's kept separate from the database model
+// so columns can change without changing the API, and internal fields like the lease aren't exposed.
+type taskResponse struct {
+	ID               int64               `json:"id"`
+	Status           model.TaskStatus    `json:"status"`
+	ErrorMessage     string              `json:"error_message,omitempty"`
+	OriginalFilename string              `json:"original_filename"`
+	Priority         string              `json:"priority"`
+	Attempts         int                 `json:"attempts"`
+	Owner            string              `json:"owner,omitempty"`
+	Tags             model.Tags          `json:"tags"`
+	CallbackURL      string              `json:"callback_url,omitempty"`
+	Renditions       []renditionResponse `json:"renditions"`
+	Outputs          []outputResponse    `json:"outputs"`
+	CreatedAt        time.Time           `json:"created_at"`
+	UpdatedAt        time.Time           `json:"updated_at"`
+}
+
+type renditionResponse struct {
+	Name       string           `json:"name"`
+	Operations model.Operations `json:"operations"`
+	MaxBytes   int64            `json:"max_bytes,omitempty"`
+	MinSSIM    float64          `json:"min_ssim,omitempty"`
+}
+
+type outputResponse struct {
+	Rendition string `json:"rendition"`
+	Key       string `json:"key"`
+	Format    string `json:"format"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	ByteSize  int64  `json:"byte_size"`
+	// Quality is the quality the image was encoded with, it's omitted for lossless formats
+	Quality int `json:"quality,omitempty"`
+	// SSIM is the measured similarity of the output when its quality was chosen by SSIM
+	SSIM float64 `json:"ssim,omitempty"`
+	// URL is the path the image is downloaded from, relative to the host of the API. It's signed when signed URLs
+	// are enabled, and only given to the owner of the task and to admins.
+	URL       string    `json:"url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// newTaskResponse builds the response of a task for a caller, the download URLs are left out unless the caller can
+// access the task
+func (h *handler) newTaskResponse(task *model.ImageProcessingTask, caller auth.Caller) taskResponse {
+	response := taskResponse{
+		ID:               task.ID,
+		Status:           task.Status,
+		ErrorMessage:     task.ErrorMessage,
+		OriginalFilename: task.OriginalFilename,
+		Priority:         task.Priority.String(),
+		Attempts:         task.Attempts,
+		Owner:            task.Owner,
+		Tags:             task.Tags,
+		CallbackURL:      task.CallbackURL,
+		Renditions:       make([]renditionResponse, 0, len(task.Renditions)),
+		Outputs:          make([]outputResponse, 0, len(task.ProcessedImages)),
+		CreatedAt:        task.CreatedAt,
+		UpdatedAt:        task.UpdatedAt,
+	}
+	if response.Tags == nil {
+		response.Tags = model.Tags{}
+	}
+
+	for _, rendition := range task.Renditions {
+		response.Renditions = append(response.Renditions, renditionResponse{Name: rendition.Name, Operations: rendition.Operations, MaxBytes: rendition.MaxBytes, MinSSIM: rendition.MinSSIM})
+	}
+
+	withURLs := caller.CanAccess(task.Owner)
+	for _, image := range task.ProcessedImages {
+		output := outputResponse{
+			Rendition: image.Rendition,
+			Key:       image.StorageKey,
+			Format:    image.Format,
+			Width:     image.Width,
+			Height:    image.Height,
+			ByteSize:  image.ByteSize,
+			Quality:   image.Quality,
+			SSIM:      image.SSIM,
+			CreatedAt: image.CreatedAt,
+		}
+		if withURLs {
+			output.URL = h.imageURL(image.StorageKey)
+		}
+		response.Outputs = append(response.Outputs, output)
+	}
+
+	return response
+}
+
+func (h *handler) newTaskResponses(tasks []model.ImageProcessingTask, caller auth.Caller) []taskResponse {
+	responses := make([]taskResponse, len(tasks))
+	for i := range tasks {
+		responses[i] = h.newTaskResponse(&tasks[i], caller)
+	}
+	return responses
+}
+
+// imageURL is the path of the GetImage endpoint for a storage key, signed with the default expiry when signed URLs
+// are enabled
+func (h *handler) imageURL(key string) string {
+	path := imagePath(key)
+	if h.signer == nil {
+		return (&url.URL{Path: path}).String()
+	}
+	return h.signer.Sign(path, nil, time.Now().Add(h.signer.TTL()))
+}
+
+// imagePath is the unescaped path of the GetImage endpoint for a storage key
+func imagePath(key string) string {
+	return "/api/v1/image/" + key
+}
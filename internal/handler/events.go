@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/auth"
+	"github.com/mahdi-vajdi/go-image-processor/internal/processing"
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
+)
+
+const (
+	// eventsResyncInterval is how often the stream reads the task from the database, to catch the changes made
+	// by other instances or missed events. It also keeps the connection alive.
+	eventsResyncInterval = 10 * time.Second
+	// eventsWriteTimeout replaces the server write timeout, which would end the stream
+	eventsWriteTimeout = 30 * time.Second
+)
+
+// StreamTaskEvents streams the progress of a task as server-sent events until it reaches a terminal status or
+// the client disconnects. The first event is the current status of the task.
+func (h *handler) StreamTaskEvents(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	taskID, err := strconv.ParseInt(vars["taskId"], 10, 64)
+	if err != nil {
+		ErrorJSON(w, http.StatusBadRequest, "invalid task ID")
+		return
+	}
+
+	task, err := h.getTaskForEvents(r.Context(), taskID)
+	if err != nil {
+		taskErrorJSON(w, err)
+		return
+	}
+	// The tasks of other owners look the same as the missing ones, so their IDs can't be probed
+	if !auth.CallerFrom(r.Context()).CanAccess(task.Owner) {
+		ErrorJSON(w, http.StatusNotFound, "task not found")
+		return
+	}
+
+	// Subscribe before reading the status the stream starts from, so no event is lost in between
+	events, unsubscribe := h.processor.Subscribe(taskID)
+	defer unsubscribe()
+
+	if task, err = h.getTaskForEvents(r.Context(), taskID); err != nil {
+		taskErrorJSON(w, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Disable proxy buffering, e.g. nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	status := task.Status
+	if err = writeEvent(w, rc, statusEvent(task)); err != nil || status.IsTerminal() {
+		return
+	}
+
+	ticker := time.NewTicker(eventsResyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event := <-events:
+			status = event.Status
+			if err = writeEvent(w, rc, event); err != nil || status.IsTerminal() {
+				return
+			}
+
+		case <-ticker.C:
+			task, err = h.getTaskForEvents(r.Context(), taskID)
+			if err != nil {
+				log.Printf("Failed to resync the events of task %d: %v", taskID, err)
+				if err = writeComment(w, rc, "keepalive"); err != nil {
+					return
+				}
+				continue
+			}
+
+			if task.Status == status {
+				if err = writeComment(w, rc, "keepalive"); err != nil {
+					return
+				}
+				continue
+			}
+
+			status = task.Status
+			if err = writeEvent(w, rc, statusEvent(task)); err != nil || status.IsTerminal() {
+				return
+			}
+		}
+	}
+}
+
+func (h *handler) getTaskForEvents(ctx context.Context, taskID int64) (*model.ImageProcessingTask, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return h.repo.GetTaskByID(ctx, taskID)
+}
+
+func taskErrorJSON(w http.ResponseWriter, err error) {
+	if errors.Is(err, repository.ErrTaskNotFound) {
+		ErrorJSON(w, http.StatusNotFound, "task not found")
+	} else {
+		ErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("failed to get task: %v", err))
+	}
+}
+
+func statusEvent(task *model.ImageProcessingTask) processing.Event {
+	return processing.Event{
+		Type:    processing.EventStatus,
+		TaskID:  task.ID,
+		Status:  task.Status,
+		Attempt: task.Attempts,
+		Error:   task.ErrorMessage,
+		Time:    task.UpdatedAt,
+	}
+}
+
+func writeEvent(w http.ResponseWriter, rc *http.ResponseController, event processing.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_ = rc.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+	if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+		return err
+	}
+
+	return rc.Flush()
+}
+
+// writeComment writes a line that SSE clients ignore
+func writeComment(w http.ResponseWriter, rc *http.ResponseController, comment string) error {
+	_ = rc.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+	if _, err := fmt.Fprintf(w, ": %s\n\n", comment); err != nil {
+		return err
+	}
+
+	return rc.Flush()
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -10,11 +11,12 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/auth"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/signedurl"
 	"github.com/mahdi-vajdi/go-image-processor/internal/platform/validator"
 	"github.com/mahdi-vajdi/go-image-processor/internal/processing"
 	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
@@ -25,7 +27,16 @@ type Handler interface {
 	Ping(w http.ResponseWriter, r *http.Request)
 	UploadImage(w http.ResponseWriter, r *http.Request)
 	GetImageStatus(w http.ResponseWriter, r *http.Request)
+	SearchTasks(w http.ResponseWriter, r *http.Request)
+	StreamTaskEvents(w http.ResponseWriter, r *http.Request)
+	CancelTask(w http.ResponseWriter, r *http.Request)
 	GetImage(w http.ResponseWriter, r *http.Request)
+	TransformImage(w http.ResponseWriter, r *http.Request)
+	SignImageURL(w http.ResponseWriter, r *http.Request)
+
+	ListFailedTasks(w http.ResponseWriter, r *http.Request)
+	RequeueTasks(w http.ResponseWriter, r *http.Request)
+	PurgeTasks(w http.ResponseWriter, r *http.Request)
 }
 
 type handler struct {
@@ -33,17 +44,44 @@ type handler struct {
 	imageStore storage.Storage
 	processor  *processing.Service
 	validate   *validator.Validator
+	// signer signs the image URLs of the responses, it's nil when signed URLs are disabled
+	signer *signedurl.Signer
+	config Config
+}
+
+type Config struct {
+	// RedirectDownloads makes GetImage redirect to a presigned URL of the storage instead of streaming the image,
+	// when the storage is a storage.Presigner
+	RedirectDownloads bool
+	PresignExpiry     time.Duration
 }
 
-func NewHandler(repo repository.Repository, imageStore storage.Storage, processor *processing.Service, val *validator.Validator) Handler {
+func NewHandler(repo repository.Repository, imageStore storage.Storage, processor *processing.Service, val *validator.Validator, signer *signedurl.Signer, config Config) Handler {
+	if config.PresignExpiry <= 0 {
+		config.PresignExpiry = 5 * time.Minute
+		log.Printf("Warning: PresignExpiry not set or invalid, defaulting to %s", config.PresignExpiry)
+	}
+
 	return &handler{
 		repo:       repo,
 		imageStore: imageStore,
 		processor:  processor,
 		validate:   val,
+		signer:     signer,
+		config:     config,
 	}
 }
 
+type uploadImageRequest struct {
+	Operations model.Operations  `json:"operations" validate:"max=32,dive"`
+	Renditions []model.Rendition `json:"renditions" validate:"max=16,unique=Name,dive"`
+	Priority   string            `json:"priority" validate:"omitempty,oneof=low normal high"`
+	// CallbackURL receives a signed webhook when the task is done
+	CallbackURL string   `json:"callback_url" validate:"omitempty,max=2048,http_url"`
+	Owner       string   `json:"owner" validate:"max=255"`
+	Tags        []string `json:"tags" validate:"max=16,unique,dive,required,max=64"`
+}
+
 func (h *handler) Ping(w http.ResponseWriter, _ *http.Request) {
 	ResponseJSON(w, http.StatusOK, map[string]string{"message": "pong"})
 }
@@ -75,15 +113,90 @@ func (h *handler) UploadImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	req := uploadImageRequest{Priority: r.FormValue("priority"), CallbackURL: r.FormValue("callback_url"), Owner: r.FormValue("owner")}
+	if operations := r.FormValue("operations"); operations != "" {
+		if err = json.Unmarshal([]byte(operations), &req.Operations); err != nil {
+			ErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid operations: %v", err))
+			return
+		}
+	}
+
+	if renditions := r.FormValue("renditions"); renditions != "" {
+		if err = json.Unmarshal([]byte(renditions), &req.Renditions); err != nil {
+			ErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid renditions: %v", err))
+			return
+		}
+	}
+
+	if tags := r.FormValue("tags"); tags != "" {
+		if err = json.Unmarshal([]byte(tags), &req.Tags); err != nil {
+			ErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid tags: %v", err))
+			return
+		}
+	}
+
+	if err = h.validate.Validate(req); err != nil {
+		ValidationErrorJSON(w, err)
+		return
+	}
+
+	// The tasks of a client belong to the owner of its API key, only admins can create them for another owner
+	caller := auth.CallerFrom(ctx)
+	if !caller.Admin && req.Owner != "" && req.Owner != caller.Owner {
+		ErrorJSON(w, http.StatusForbidden, "owner must be the owner of the API key")
+		return
+	}
+	if caller.Owner != "" {
+		req.Owner = caller.Owner
+	}
+
+	task := &model.ImageProcessingTask{
+		OriginalFilename: originalFilename,
+		Operations:       req.Operations,
+		Renditions:       req.Renditions,
+		Priority:         model.PriorityNormal,
+		CallbackURL:      req.CallbackURL,
+		Owner:            req.Owner,
+		Tags:             req.Tags,
+	}
+	if req.Priority != "" {
+		// Already checked by the validator
+		task.Priority, _ = model.ParsePriority(req.Priority)
+	}
+
+	// Compile the plan once to catch the operations that are well-formed but not runnable
+	if _, err = processing.NewPlan(task); err != nil {
+		ErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid operations: %v", err))
+		return
+	}
+
+	// Images the binary can't decode would only fail in the worker, reject them right away
+	if _, err = processing.DetectCodec(file); err != nil {
+		ErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid image: %v", err))
+		return
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		ErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("failed to read image: %v", err))
+		return
+	}
+
 	storageKey, err := h.imageStore.Save(ctx, originalFilename, file)
 	if err != nil {
 		ErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("failed to save image: %v", err))
 		return
 	}
 
-	task := &model.ImageProcessingTask{OriginalFilename: originalFilename, StorageKey: storageKey}
+	task.StorageKey = storageKey
 
-	createdTask, err := h.repo.CreateTask(ctx, task)
+	// The task and its renditions are created together, so the poller never claims a task without them
+	var createdTask *model.ImageProcessingTask
+	err = h.repo.WithTx(ctx, func(repo repository.Repository) error {
+		var err error
+		if createdTask, err = repo.CreateTask(ctx, task); err != nil {
+			return err
+		}
+		return repo.CreateRenditions(ctx, createdTask)
+	})
 	if err != nil {
 		log.Printf("Warning: failed to create task for storage key %s: %v", storageKey, err)
 		cleanUpErr := h.imageStore.Delete(context.Background(), storageKey)
@@ -116,6 +229,36 @@ func (h *handler) GetImageStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	task, err := h.repo.GetTaskWithOutputs(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, repository.ErrTaskNotFound) {
+			ErrorJSON(w, http.StatusNotFound, "task not found")
+		} else {
+			ErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("failed to get task: %v", err))
+		}
+		return
+	}
+	// The tasks of other owners look the same as the missing ones, so their IDs can't be probed
+	caller := auth.CallerFrom(ctx)
+	if !caller.CanAccess(task.Owner) {
+		ErrorJSON(w, http.StatusNotFound, "task not found")
+		return
+	}
+
+	ResponseJSON(w, http.StatusOK, h.newTaskResponse(task, caller))
+}
+
+func (h *handler) CancelTask(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	vars := mux.Vars(r)
+	taskID, err := strconv.ParseInt(vars["taskId"], 10, 64)
+	if err != nil {
+		ErrorJSON(w, http.StatusBadRequest, "invalid task ID")
+		return
+	}
+
 	task, err := h.repo.GetTaskByID(ctx, taskID)
 	if err != nil {
 		if errors.Is(err, repository.ErrTaskNotFound) {
@@ -125,12 +268,36 @@ func (h *handler) GetImageStatus(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	// The tasks of other owners look the same as the missing ones, so their IDs can't be probed
+	if !auth.CallerFrom(ctx).CanAccess(task.Owner) {
+		ErrorJSON(w, http.StatusNotFound, "task not found")
+		return
+	}
 
-	ResponseJSON(w, http.StatusOK, task)
+	previousStatus, err := h.repo.CancelTask(ctx, taskID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrTaskNotFound):
+			ErrorJSON(w, http.StatusNotFound, "task not found")
+		case errors.Is(err, repository.ErrTaskNotCancellable):
+			ErrorJSON(w, http.StatusConflict, fmt.Sprintf("task is already %s and can't be cancelled", previousStatus))
+		default:
+			ErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("failed to cancel task: %v", err))
+		}
+		return
+	}
+
+	// Abort it right away if this instance is processing it, otherwise its instance will notice the lost lease
+	h.processor.Cancel(taskID)
+
+	ResponseJSON(w, http.StatusOK, map[string]string{"id": strconv.FormatInt(taskID, 10), "status": string(model.StatusCancelled)})
 }
 
+// GetImage serves a stored image. Clients that accept a better format than the one of the image, e.g. with
+// "Accept: image/avif,image/webp", get another output of the same task in that format or the image transcoded to it.
 func (h *handler) GetImage(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	// Transcoding takes longer than downloading
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
 	vars := mux.Vars(r)
@@ -140,6 +307,34 @@ func (h *handler) GetImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("Vary", "Accept")
+
+	imageKey, transcodeTo := h.negotiateImage(ctx, imageKey, parseAccept(r.Header.Get("Accept")))
+	if transcodeTo != nil {
+		transformed, err := h.processor.Transform(ctx, imageKey, model.Operations{{Type: model.OpFormat, Format: transcodeTo.Name}})
+		if err != nil {
+			transformErrorJSON(w, err)
+			return
+		}
+
+		writeTransformed(w, r, imageKey, transformed)
+		return
+	}
+
+	// Let the storage serve the image when it can, the API only signs the request
+	if presigner, ok := h.imageStore.(storage.Presigner); ok && h.config.RedirectDownloads {
+		presignedURL, err := presigner.PresignGet(ctx, imageKey, h.config.PresignExpiry, processing.ContentType(filepath.Ext(imageKey)))
+		if err != nil {
+			ErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("failed to presign image URL: %v", err))
+			return
+		}
+
+		// The presigned URL expires, so the redirect itself must not be cached
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, presignedURL, http.StatusFound)
+		return
+	}
+
 	imageReader, err := h.imageStore.Get(ctx, imageKey)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -152,18 +347,7 @@ func (h *handler) GetImage(w http.ResponseWriter, r *http.Request) {
 	defer imageReader.Close()
 
 	// TODO: store and retrieve the content type using the storage metadata
-	imageExt := strings.ToLower(filepath.Ext(imageKey))
-	contentType := "application/octet-stream"
-	switch imageExt {
-	case ".jpg", ".jpeg":
-		contentType = "image/jpeg"
-	case ".png":
-		contentType = "image/png"
-	case ".gif":
-		contentType = "image/gif"
-	}
-
-	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Type", processing.ContentType(filepath.Ext(imageKey)))
 	// TODO: set the size too
 
 	_, err = io.Copy(w, imageReader)
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/auth"
+	"github.com/mahdi-vajdi/go-image-processor/internal/processing"
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
+)
+
+// fakeTaskRepository holds tasks in memory, the methods the handlers under test don't call aren't implemented
+type fakeTaskRepository struct {
+	repository.Repository
+	tasks     map[int64]*model.ImageProcessingTask
+	cancelled []int64
+}
+
+func newFakeTaskRepository(tasks ...*model.ImageProcessingTask) *fakeTaskRepository {
+	r := &fakeTaskRepository{tasks: make(map[int64]*model.ImageProcessingTask)}
+	for _, task := range tasks {
+		r.tasks[task.ID] = task
+	}
+	return r
+}
+
+func (r *fakeTaskRepository) GetTaskByID(_ context.Context, id int64) (*model.ImageProcessingTask, error) {
+	task, ok := r.tasks[id]
+	if !ok {
+		return nil, fmt.Errorf("task with ID %d was not found: %w", id, repository.ErrTaskNotFound)
+	}
+	return task, nil
+}
+
+func (r *fakeTaskRepository) GetTaskWithOutputs(ctx context.Context, id int64) (*model.ImageProcessingTask, error) {
+	return r.GetTaskByID(ctx, id)
+}
+
+func (r *fakeTaskRepository) CancelTask(ctx context.Context, id int64) (model.TaskStatus, error) {
+	task, err := r.GetTaskByID(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	r.cancelled = append(r.cancelled, id)
+	return task.Status, nil
+}
+
+// taskRequest calls a handler of a task route as the caller
+func taskRequest(handler http.HandlerFunc, method string, taskID int64, caller auth.Caller) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/v1/image/tasks/"+strconv.FormatInt(taskID, 10), nil)
+	req = mux.SetURLVars(req, map[string]string{"taskId": strconv.FormatInt(taskID, 10)})
+	req = req.WithContext(auth.WithCaller(req.Context(), caller))
+
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestCancelTaskChecksOwner(t *testing.T) {
+	tests := []struct {
+		name       string
+		caller     auth.Caller
+		wantStatus int
+	}{
+		{"owner", auth.Caller{Owner: "acme"}, http.StatusOK},
+		{"admin", auth.Caller{Admin: true}, http.StatusOK},
+		{"another owner", auth.Caller{Owner: "globex"}, http.StatusNotFound},
+		{"anonymous", auth.Caller{}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeTaskRepository(&model.ImageProcessingTask{ID: 1, Owner: "acme", Status: model.StatusPending})
+			h := &handler{repo: repo, processor: processing.NewService(repo, nil, processing.ServiceConfig{})}
+
+			rec := taskRequest(h.CancelTask, http.MethodPost, 1, tt.caller)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if cancelled := len(repo.cancelled) > 0; cancelled != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("task cancelled = %v, want %v", cancelled, tt.wantStatus == http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestGetImageStatusChecksOwner(t *testing.T) {
+	repo := newFakeTaskRepository(&model.ImageProcessingTask{ID: 1, Owner: "acme", Status: model.StatusCompleted, CallbackURL: "https://acme.example.com/hook"})
+	h := &handler{repo: repo}
+
+	tests := []struct {
+		name       string
+		taskID     int64
+		caller     auth.Caller
+		wantStatus int
+	}{
+		{"owner", 1, auth.Caller{Owner: "acme"}, http.StatusOK},
+		{"admin", 1, auth.Caller{Admin: true}, http.StatusOK},
+		{"another owner", 1, auth.Caller{Owner: "globex"}, http.StatusNotFound},
+		{"anonymous", 1, auth.Caller{}, http.StatusNotFound},
+		{"missing task", 2, auth.Caller{Admin: true}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := taskRequest(h.GetImageStatus, http.MethodGet, tt.taskID, tt.caller)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if leaked := strings.Contains(rec.Body.String(), "acme.example.com"); leaked != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("callback URL in the response = %v: %s", leaked, rec.Body)
+			}
+		})
+	}
+}
+
+func TestStreamTaskEventsChecksOwner(t *testing.T) {
+	// A completed task ends the stream after its first event
+	repo := newFakeTaskRepository(&model.ImageProcessingTask{ID: 1, Owner: "acme", Status: model.StatusCompleted})
+	h := &handler{repo: repo, processor: processing.NewService(repo, nil, processing.ServiceConfig{})}
+
+	tests := []struct {
+		name       string
+		caller     auth.Caller
+		wantStatus int
+	}{
+		{"owner", auth.Caller{Owner: "acme"}, http.StatusOK},
+		{"admin", auth.Caller{Admin: true}, http.StatusOK},
+		{"another owner", auth.Caller{Owner: "globex"}, http.StatusNotFound},
+		{"anonymous", auth.Caller{}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := taskRequest(h.StreamTaskEvents, http.MethodGet, 1, tt.caller)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if streamed := strings.Contains(rec.Body.String(), `"status":"completed"`); streamed != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("status event streamed = %v: %s", streamed, rec.Body)
+			}
+		})
+	}
+}
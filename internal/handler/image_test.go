@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mahdi-vajdi/go-image-processor/internal/storage"
+	localStorage "github.com/mahdi-vajdi/go-image-processor/internal/storage/local"
+)
+
+// fakePresigner is a storage that can presign its keys, it records what it was asked to presign
+type fakePresigner struct {
+	storage.Storage
+	key         string
+	expires     time.Duration
+	contentType string
+}
+
+func (p *fakePresigner) PresignGet(_ context.Context, key string, expires time.Duration, contentType string) (string, error) {
+	p.key, p.expires, p.contentType = key, expires, contentType
+	return "https://bucket.example.com/" + key + "?X-Amz-Signature=abc", nil
+}
+
+func newImageStore(t *testing.T) storage.Storage {
+	t.Helper()
+
+	store, err := localStorage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore() = %v", err)
+	}
+	if err = store.Put(context.Background(), "photo.png", strings.NewReader("png data")); err != nil {
+		t.Fatalf("Put() = %v", err)
+	}
+	return store
+}
+
+func getImage(h *handler, key string) *httptest.ResponseRecorder {
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/v1/image/"+key, nil), map[string]string{"imageKey": key})
+	rec := httptest.NewRecorder()
+	h.GetImage(rec, req)
+	return rec
+}
+
+func TestGetImageRedirectsToPresignedURL(t *testing.T) {
+	presigner := &fakePresigner{Storage: newImageStore(t)}
+	h := &handler{imageStore: presigner, config: Config{RedirectDownloads: true, PresignExpiry: 5 * time.Minute}}
+
+	rec := getImage(h, "photo.png")
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusFound)
+	}
+	if location := rec.Header().Get("Location"); location != "https://bucket.example.com/photo.png?X-Amz-Signature=abc" {
+		t.Errorf("Location = %q", location)
+	}
+	if cacheControl := rec.Header().Get("Cache-Control"); cacheControl != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", cacheControl)
+	}
+	if presigner.key != "photo.png" || presigner.expires != 5*time.Minute || presigner.contentType != "image/png" {
+		t.Errorf("PresignGet(%q, %s, %q), want photo.png, 5m and image/png", presigner.key, presigner.expires, presigner.contentType)
+	}
+}
+
+func TestGetImageStreamsWithoutRedirects(t *testing.T) {
+	tests := []struct {
+		name     string
+		store    func(t *testing.T) storage.Storage
+		redirect bool
+	}{
+		{"storage without presigning", newImageStore, true},
+		{"redirects disabled", func(t *testing.T) storage.Storage { return &fakePresigner{Storage: newImageStore(t)} }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := tt.store(t)
+			h := &handler{imageStore: store, config: Config{RedirectDownloads: tt.redirect}}
+
+			rec := getImage(h, "photo.png")
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if body := rec.Body.String(); body != "png data" {
+				t.Errorf("body = %q, want the stored image", body)
+			}
+			if contentType := rec.Header().Get("Content-Type"); contentType != "image/png" {
+				t.Errorf("Content-Type = %q, want image/png", contentType)
+			}
+			if presigner, ok := store.(*fakePresigner); ok && presigner.key != "" {
+				t.Errorf("the image was presigned with redirects disabled")
+			}
+		})
+	}
+}
+
+func TestGetImageNotFound(t *testing.T) {
+	h := &handler{imageStore: newImageStore(t)}
+
+	if rec := getImage(h, "missing.png"); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/processing"
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
+)
+
+// modernFormats are the formats images are upgraded to when the client accepts them, from the most to the least
+// preferred. They are usually much smaller than JPEG and PNG.
+var modernFormats = []string{"avif", "webp"}
+
+// acceptHeader maps the content types of an Accept header to their quality.
+type acceptHeader map[string]float64
+
+func parseAccept(header string) acceptHeader {
+	accept := acceptHeader{}
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		if mediaType == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if name == "q" {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		accept[mediaType] = quality
+	}
+	return accept
+}
+
+// upgrades returns the modern formats the client explicitly accepts, best first, that are better than the current
+// one. Wildcards don't count, so clients that accept anything still get the format they asked for.
+func (a acceptHeader) upgrades(current *processing.Codec) []*processing.Codec {
+	var codecs []*processing.Codec
+	for _, name := range modernFormats {
+		codec, _ := processing.LookupCodec(name)
+		if codec == current {
+			break
+		}
+		if a[codec.ContentType] > 0 {
+			codecs = append(codecs, codec)
+		}
+	}
+
+	// The formats are already in the order of preference, which breaks the ties
+	sort.SliceStable(codecs, func(i, j int) bool {
+		return a[codecs[i].ContentType] > a[codecs[j].ContentType]
+	})
+
+	return codecs
+}
+
+// negotiateImage picks what to serve for an image key and an Accept header: a stored output of the same task in the
+// same size and a better format, or else a better format to transcode the image to. It returns the key itself and
+// no codec when the client doesn't accept a better format, or none is available.
+func (h *handler) negotiateImage(ctx context.Context, key string, accept acceptHeader) (string, *processing.Codec) {
+	current, _ := processing.LookupCodec(filepath.Ext(key))
+	upgrades := accept.upgrades(current)
+	if len(upgrades) == 0 {
+		return key, nil
+	}
+
+	siblings, err := h.sameSizeOutputs(ctx, key)
+	if err != nil {
+		// Serving the requested image is better than failing
+		log.Printf("Warning: failed to find the other outputs of image %s: %v", key, err)
+	}
+
+	// Stored outputs first, they don't cost a transformation
+	for _, codec := range upgrades {
+		for _, sibling := range siblings {
+			if siblingCodec, ok := processing.LookupCodec(sibling.Format); ok && siblingCodec == codec {
+				return sibling.StorageKey, nil
+			}
+		}
+	}
+
+	for _, codec := range upgrades {
+		if codec.CanEncode() {
+			return key, codec
+		}
+	}
+
+	return key, nil
+}
+
+// sameSizeOutputs returns the other outputs of the task that produced the image key that are the same picture in
+// another format: their rendition runs the same operations apart from the format and the encoder options, and they
+// have the same size, which a rendition scaled down to fit in its byte limit doesn't
+func (h *handler) sameSizeOutputs(ctx context.Context, key string) ([]model.ProcessedImage, error) {
+	image, err := h.repo.GetProcessedImageByKey(ctx, key)
+	if err != nil {
+		if errors.Is(err, repository.ErrProcessedImageNotFound) {
+			// An original or a derived image
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	task, err := h.repo.GetTaskWithOutputs(ctx, image.TaskID)
+	if err != nil {
+		return nil, err
+	}
+
+	renditions := make(map[string]model.Operations, len(task.Renditions))
+	for _, rendition := range task.Renditions {
+		renditions[rendition.Name] = pictureOperations(rendition.Operations)
+	}
+	picture, ok := renditions[image.Rendition]
+	if !ok {
+		// A task without renditions has a single output
+		return nil, nil
+	}
+
+	var siblings []model.ProcessedImage
+	for _, output := range task.ProcessedImages {
+		if output.ID == image.ID || output.Width != image.Width || output.Height != image.Height {
+			continue
+		}
+		if ops, ok := renditions[output.Rendition]; ok && slices.Equal(ops, picture) {
+			siblings = append(siblings, output)
+		}
+	}
+
+	return siblings, nil
+}
+
+// pictureOperations returns the operations that change the pixels of an output, without the ones that only choose
+// how it's encoded
+func pictureOperations(ops model.Operations) model.Operations {
+	var picture model.Operations
+	for _, op := range ops {
+		switch op.Type {
+		case model.OpFormat, model.OpQuality, model.OpEncode:
+			continue
+		}
+		picture = append(picture, op)
+	}
+	return picture
+}
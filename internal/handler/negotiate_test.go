@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
+)
+
+// fakeOutputsRepository serves a single task and its outputs, the other methods aren't used by the negotiation
+type fakeOutputsRepository struct {
+	repository.Repository
+	task *model.ImageProcessingTask
+}
+
+func (r *fakeOutputsRepository) GetProcessedImageByKey(_ context.Context, key string) (*model.ProcessedImage, error) {
+	for _, output := range r.task.ProcessedImages {
+		if output.StorageKey == key {
+			return &output, nil
+		}
+	}
+	return nil, fmt.Errorf("processed image %s: %w", key, repository.ErrProcessedImageNotFound)
+}
+
+func (r *fakeOutputsRepository) GetTaskWithOutputs(_ context.Context, id int64) (*model.ImageProcessingTask, error) {
+	if id != r.task.ID {
+		return nil, repository.ErrTaskNotFound
+	}
+	return r.task, nil
+}
+
+func TestNegotiateImagePicksTheSamePicture(t *testing.T) {
+	thumb := model.Operation{Type: model.OpResize, Width: 150, Height: 150, Fit: "fill"}
+	task := &model.ImageProcessingTask{
+		ID: 1,
+		Renditions: []model.Rendition{
+			{Name: "thumb", Operations: model.Operations{thumb}},
+			{Name: "thumb_gray", Operations: model.Operations{thumb, {Type: model.OpGrayscale}}},
+			{Name: "thumb_top", Operations: model.Operations{{Type: model.OpResize, Width: 150, Height: 150, Fit: "fill", Anchor: "top"}, {Type: model.OpFormat, Format: "webp"}}},
+			{Name: "thumb_webp", Operations: model.Operations{thumb, {Type: model.OpFormat, Format: "webp"}, {Type: model.OpEncode, Quality: 70}}},
+		},
+		ProcessedImages: []model.ProcessedImage{
+			{ID: 1, TaskID: 1, Rendition: "thumb", Format: "jpeg", Width: 150, Height: 150, StorageKey: "1/thumb.jpeg"},
+			{ID: 2, TaskID: 1, Rendition: "thumb_gray", Format: "avif", Width: 150, Height: 150, StorageKey: "1/thumb_gray.avif"},
+			{ID: 3, TaskID: 1, Rendition: "thumb_top", Format: "webp", Width: 150, Height: 150, StorageKey: "1/thumb_top.webp"},
+			{ID: 4, TaskID: 1, Rendition: "thumb_webp", Format: "webp", Width: 150, Height: 150, StorageKey: "1/thumb_webp.webp"},
+		},
+	}
+	h := &handler{repo: &fakeOutputsRepository{task: task}}
+
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{"same operations in another format", "image/webp", "1/thumb_webp.webp"},
+		{"only a different picture in the format", "image/avif", "1/thumb.jpeg"},
+		{"no better format", "image/jpeg", "1/thumb.jpeg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, codec := h.negotiateImage(context.Background(), "1/thumb.jpeg", parseAccept(tt.accept))
+			if key != tt.want {
+				t.Errorf("negotiateImage() key = %s, want %s", key, tt.want)
+			}
+			if codec != nil && !codec.CanEncode() {
+				t.Errorf("negotiateImage() codec = %s, which this build can't encode", codec.Name)
+			}
+		})
+	}
+}
+
+func TestPictureOperations(t *testing.T) {
+	ops := model.Operations{
+		{Type: model.OpResize, Width: 100},
+		{Type: model.OpFormat, Format: "png"},
+		{Type: model.OpQuality, Quality: 80},
+		{Type: model.OpBlur, Sigma: 2},
+		{Type: model.OpEncode, Compression: "best"},
+	}
+
+	got := pictureOperations(ops)
+	want := model.Operations{{Type: model.OpResize, Width: 100}, {Type: model.OpBlur, Sigma: 2}}
+	if !slices.Equal(got, want) {
+		t.Errorf("pictureOperations() = %v, want %v", got, want)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/validator"
 )
 
 func ResponseJSON(w http.ResponseWriter, status int, data any) {
@@ -19,3 +21,16 @@ func ResponseJSON(w http.ResponseWriter, status int, data any) {
 func ErrorJSON(w http.ResponseWriter, status int, message string) {
 	ResponseJSON(w, status, map[string]string{"message": message})
 }
+
+func ValidationErrorJSON(w http.ResponseWriter, err error) {
+	ResponseJSON(w, http.StatusBadRequest, map[string]any{"message": "validation failed", "errors": validator.FormatErrors(err)})
+}
+
+// queryErrorJSON responds to an invalid query string, which can fail on parsing or on validation
+func queryErrorJSON(w http.ResponseWriter, err error) {
+	if validator.IsValidationError(err) {
+		ValidationErrorJSON(w, err)
+		return
+	}
+	ErrorJSON(w, http.StatusBadRequest, err.Error())
+}
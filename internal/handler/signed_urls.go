@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/auth"
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
+)
+
+type signImageURLRequest struct {
+	// Rendition is the name of the output of the task the URL points to
+	Rendition string `json:"rendition" validate:"required,max=64"`
+	// ExpiresIn is the lifetime of the URL in seconds, it defaults to the configured expiry
+	ExpiresIn int `json:"expires_in" validate:"gte=0"`
+	// Transform makes the URL point to an on-the-fly transformation of the output
+	Transform *signImageURLTransform `json:"transform"`
+}
+
+type signImageURLTransform struct {
+	Width   int    `json:"w"`
+	Height  int    `json:"h"`
+	Fit     string `json:"fit"`
+	Format  string `json:"fmt"`
+	Quality int    `json:"q"`
+}
+
+func (t *signImageURLTransform) values() url.Values {
+	values := url.Values{}
+	if t.Width > 0 {
+		values.Set("w", strconv.Itoa(t.Width))
+	}
+	if t.Height > 0 {
+		values.Set("h", strconv.Itoa(t.Height))
+	}
+	if t.Fit != "" {
+		values.Set("fit", t.Fit)
+	}
+	if t.Format != "" {
+		values.Set("fmt", t.Format)
+	}
+	if t.Quality > 0 {
+		values.Set("q", strconv.Itoa(t.Quality))
+	}
+	return values
+}
+
+// SignImageURL mints a URL of an output of a task, or of a transformation of it, for the owner of the task or an
+// admin. The URL is signed when signed URLs are enabled and returned as is otherwise.
+func (h *handler) SignImageURL(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	caller := auth.CallerFrom(ctx)
+	if !caller.Admin && caller.Owner == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		ErrorJSON(w, http.StatusUnauthorized, "an API key is required")
+		return
+	}
+
+	taskID, err := strconv.ParseInt(mux.Vars(r)["taskId"], 10, 64)
+	if err != nil {
+		ErrorJSON(w, http.StatusBadRequest, "invalid task ID")
+		return
+	}
+
+	var req signImageURLRequest
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if err = h.validate.Validate(req); err != nil {
+		ValidationErrorJSON(w, err)
+		return
+	}
+
+	task, err := h.repo.GetTaskWithOutputs(ctx, taskID)
+	if err != nil {
+		if errors.Is(err, repository.ErrTaskNotFound) {
+			ErrorJSON(w, http.StatusNotFound, "task not found")
+		} else {
+			ErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("failed to get task: %v", err))
+		}
+		return
+	}
+	// The tasks of other owners look the same as the missing ones, so their IDs can't be probed
+	if !caller.CanAccess(task.Owner) {
+		ErrorJSON(w, http.StatusNotFound, "task not found")
+		return
+	}
+
+	key := ""
+	for _, image := range task.ProcessedImages {
+		if image.Rendition == req.Rendition {
+			key = image.StorageKey
+			break
+		}
+	}
+	if key == "" {
+		ErrorJSON(w, http.StatusNotFound, fmt.Sprintf("task has no output for rendition %q", req.Rendition))
+		return
+	}
+
+	path := imagePath(key)
+	var query url.Values
+	if req.Transform != nil {
+		query = req.Transform.values()
+		// Reject the parameters the transform endpoint would reject
+		if _, err = h.parseTransformQuery(query, key); err != nil {
+			queryErrorJSON(w, err)
+			return
+		}
+		path += "/transform"
+	}
+
+	if h.signer == nil {
+		ResponseJSON(w, http.StatusOK, map[string]any{"url": (&url.URL{Path: path, RawQuery: query.Encode()}).String()})
+		return
+	}
+
+	expiresIn := h.signer.TTL()
+	if req.ExpiresIn > 0 {
+		expiresIn = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if expiresIn > h.signer.MaxTTL() {
+		ErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("expires_in can't be over %d seconds", int(h.signer.MaxTTL().Seconds())))
+		return
+	}
+
+	expiresAt := time.Now().Add(expiresIn)
+	ResponseJSON(w, http.StatusOK, map[string]any{
+		"url":        h.signer.Sign(path, query, expiresAt),
+		"expires_at": expiresAt.UTC().Truncate(time.Second),
+	})
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/auth"
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
+)
+
+type searchTasksQuery struct {
+	Statuses []string `json:"status" validate:"dive,oneof=pending processing completed failed dead_letter cancelled"`
+	Filename string   `json:"filename" validate:"max=255"`
+	Owner    string   `json:"owner" validate:"max=255"`
+	Tags     []string `json:"tag" validate:"max=16,dive,required,max=64"`
+	Sort     string   `json:"sort" validate:"oneof=created_at -created_at updated_at -updated_at"`
+	Limit    int      `json:"limit" validate:"gte=1,lte=100"`
+}
+
+// taskCursor is the opaque cursor of the task listing. It carries its sort so it can't be used with another one.
+type taskCursor struct {
+	Sort string    `json:"s"`
+	Time time.Time `json:"t"`
+	ID   int64     `json:"id"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func (h *handler) parseSearchTasksQuery(values url.Values) (*repository.TaskSearch, string, error) {
+	q := searchTasksQuery{
+		Statuses: values["status"],
+		Filename: values.Get("filename"),
+		Owner:    values.Get("owner"),
+		Tags:     values["tag"],
+		Sort:     values.Get("sort"),
+		Limit:    20,
+	}
+	if q.Sort == "" {
+		q.Sort = "-created_at"
+	}
+
+	var err error
+	if q.Limit, err = parseIntParam(values, "limit", q.Limit); err != nil {
+		return nil, "", err
+	}
+
+	if err = h.validate.Validate(q); err != nil {
+		return nil, "", err
+	}
+
+	search := &repository.TaskSearch{
+		Filter: repository.TaskFilter{
+			FilenamePrefix: q.Filename,
+			Owner:          q.Owner,
+			Tags:           q.Tags,
+		},
+		Sort: repository.TaskSort{
+			Field:      repository.TaskSortField(strings.TrimPrefix(q.Sort, "-")),
+			Descending: strings.HasPrefix(q.Sort, "-"),
+		},
+		Limit: q.Limit,
+	}
+	for _, status := range q.Statuses {
+		search.Filter.Statuses = append(search.Filter.Statuses, model.TaskStatus(status))
+	}
+
+	if search.Filter.CreatedAfter, err = parseTimeParam(values, "created_from"); err != nil {
+		return nil, "", err
+	}
+	if search.Filter.CreatedBefore, err = parseTimeParam(values, "created_to"); err != nil {
+		return nil, "", err
+	}
+
+	if value := values.Get("cursor"); value != "" {
+		cursor, err := decodeTaskCursor(value)
+		if err != nil || cursor.Sort != q.Sort {
+			return nil, "", errInvalidCursor
+		}
+		search.After = &repository.TaskCursor{Time: cursor.Time, ID: cursor.ID}
+	}
+
+	return search, q.Sort, nil
+}
+
+// SearchTasks lists the tasks matching the query, a page at a time. The next page is requested with the
+// next_cursor of the response, which is empty on the last page. Admins search the tasks of every owner, the other
+// callers only their own.
+func (h *handler) SearchTasks(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	caller := auth.CallerFrom(ctx)
+	if !caller.Admin && caller.Owner == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		ErrorJSON(w, http.StatusUnauthorized, "an API key is required")
+		return
+	}
+
+	search, sort, err := h.parseSearchTasksQuery(r.URL.Query())
+	if err != nil {
+		queryErrorJSON(w, err)
+		return
+	}
+
+	if !caller.Admin {
+		if search.Filter.Owner != "" && search.Filter.Owner != caller.Owner {
+			ErrorJSON(w, http.StatusForbidden, "owner must be the owner of the API key")
+			return
+		}
+		search.Filter.Owner = caller.Owner
+	}
+
+	// One more task tells whether there is a next page
+	pageSize := search.Limit
+	search.Limit++
+
+	tasks, err := h.repo.SearchTasks(ctx, *search)
+	if err != nil {
+		ErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("failed to search tasks: %v", err))
+		return
+	}
+
+	total, err := h.repo.CountTasks(ctx, search.Filter)
+	if err != nil {
+		ErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("failed to count tasks: %v", err))
+		return
+	}
+
+	nextCursor := ""
+	if len(tasks) > pageSize {
+		tasks = tasks[:pageSize]
+
+		last := tasks[len(tasks)-1]
+		cursor := taskCursor{Sort: sort, Time: last.CreatedAt, ID: last.ID}
+		if search.Sort.Field == repository.SortByUpdatedAt {
+			cursor.Time = last.UpdatedAt
+		}
+		nextCursor = encodeTaskCursor(cursor)
+	}
+
+	ResponseJSON(w, http.StatusOK, map[string]any{"tasks": h.newTaskResponses(tasks, caller), "total": total, "next_cursor": nextCursor})
+}
+
+func encodeTaskCursor(cursor taskCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeTaskCursor(value string) (*taskCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor taskCursor
+	if err = json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+
+	return &cursor, nil
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/auth"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/validator"
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
+)
+
+// fakeSearchRepository records the filter of the search, it has no tasks
+type fakeSearchRepository struct {
+	repository.Repository
+	filter *repository.TaskFilter
+}
+
+func (r *fakeSearchRepository) SearchTasks(_ context.Context, search repository.TaskSearch) ([]model.ImageProcessingTask, error) {
+	r.filter = &search.Filter
+	return nil, nil
+}
+
+func (r *fakeSearchRepository) CountTasks(_ context.Context, _ repository.TaskFilter) (int64, error) {
+	return 0, nil
+}
+
+func TestSearchTasksScopesToOwner(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		caller     auth.Caller
+		wantStatus int
+		wantOwner  string
+	}{
+		{"owner", "", auth.Caller{Owner: "acme"}, http.StatusOK, "acme"},
+		{"owner parameter of the caller", "?owner=acme", auth.Caller{Owner: "acme"}, http.StatusOK, "acme"},
+		{"owner parameter of another owner", "?owner=globex", auth.Caller{Owner: "acme"}, http.StatusForbidden, ""},
+		{"anonymous", "", auth.Caller{}, http.StatusUnauthorized, ""},
+		{"admin", "", auth.Caller{Admin: true}, http.StatusOK, ""},
+		{"admin with an owner parameter", "?owner=globex", auth.Caller{Admin: true}, http.StatusOK, "globex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeSearchRepository{}
+			h := &handler{repo: repo, validate: validator.New()}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/image/tasks"+tt.query, nil)
+			req = req.WithContext(auth.WithCaller(req.Context(), tt.caller))
+			rec := httptest.NewRecorder()
+			h.SearchTasks(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if repo.filter != nil {
+					t.Error("the tasks were searched")
+				}
+				return
+			}
+			if repo.filter.Owner != tt.wantOwner {
+				t.Errorf("searched the tasks of owner %q, want %q", repo.filter.Owner, tt.wantOwner)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/signedurl"
+	"github.com/mahdi-vajdi/go-image-processor/internal/processing"
+)
+
+type transformQuery struct {
+	Width   int    `json:"w" validate:"gte=0,lte=10000"`
+	Height  int    `json:"h" validate:"gte=0,lte=10000"`
+	Fit     string `json:"fit" validate:"omitempty,oneof=cover contain stretch"`
+	Format  string `json:"fmt" validate:"omitempty,oneof=jpeg jpg png gif tiff bmp webp avif"`
+	Quality int    `json:"q" validate:"gte=0,lte=100"`
+}
+
+// fitModes maps the fit of the query to the fit of a resize operation
+var fitModes = map[string]string{
+	"cover":   "fill",
+	"contain": "fit",
+	"stretch": "",
+}
+
+func (h *handler) parseTransformQuery(values url.Values, imageKey string) (model.Operations, error) {
+	var q transformQuery
+	var err error
+	if q.Width, err = parseIntParam(values, "w", 0); err != nil {
+		return nil, err
+	}
+	if q.Height, err = parseIntParam(values, "h", 0); err != nil {
+		return nil, err
+	}
+	if q.Quality, err = parseIntParam(values, "q", 0); err != nil {
+		return nil, err
+	}
+	q.Fit = values.Get("fit")
+	q.Format = strings.ToLower(values.Get("fmt"))
+
+	if err = h.validate.Validate(q); err != nil {
+		return nil, err
+	}
+
+	var ops model.Operations
+	if q.Width > 0 || q.Height > 0 {
+		op := model.Operation{Type: model.OpResize, Width: q.Width, Height: q.Height}
+		if q.Width > 0 && q.Height > 0 {
+			// Both sides keep the aspect ratio unless the fit says otherwise
+			op.Fit = fitModes["contain"]
+			if q.Fit != "" {
+				op.Fit = fitModes[q.Fit]
+			}
+		} else if q.Fit != "" {
+			return nil, fmt.Errorf("invalid fit: both w and h are required")
+		}
+		ops = append(ops, op)
+	}
+
+	// The output keeps the format of the original unless another one is requested
+	format := q.Format
+	if format == "" {
+		format = strings.ToLower(strings.TrimPrefix(filepath.Ext(imageKey), "."))
+		if _, err = processing.NewPipeline(model.Operations{{Type: model.OpFormat, Format: format}}); err != nil {
+			format = ""
+		}
+	}
+	if format == "jpg" {
+		// Both give the same output, and the cache key of the output depends on the operations
+		format = "jpeg"
+	}
+	if format != "" {
+		ops = append(ops, model.Operation{Type: model.OpFormat, Format: format})
+	}
+
+	if q.Quality > 0 {
+		ops = append(ops, model.Operation{Type: model.OpQuality, Quality: q.Quality})
+	}
+
+	return ops, nil
+}
+
+// TransformImage transforms a stored image on the fly with the w, h, fit, fmt and q query parameters and responds
+// with the result, so sizes don't have to be declared as renditions upfront. A variant is looked up in the in-memory
+// LRU, then in the storage under derived/, and only transformed when neither has it, once for the concurrent requests
+// of it. The stored variants are reaped after the DerivedTTL of the service.
+func (h *handler) TransformImage(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	imageKey := mux.Vars(r)["imageKey"]
+	if imageKey == "" {
+		ErrorJSON(w, http.StatusBadRequest, "missing image key")
+		return
+	}
+
+	w.Header().Set("Vary", "Accept")
+
+	// Without an explicit format, the client gets the best one it accepts
+	values := r.URL.Query()
+	if values.Get("fmt") == "" {
+		current, _ := processing.LookupCodec(filepath.Ext(imageKey))
+		for _, codec := range parseAccept(r.Header.Get("Accept")).upgrades(current) {
+			if codec.CanEncode() {
+				values.Set("fmt", codec.Name)
+				break
+			}
+		}
+	}
+
+	ops, err := h.parseTransformQuery(values, imageKey)
+	if err != nil {
+		queryErrorJSON(w, err)
+		return
+	}
+
+	transformed, err := h.processor.Transform(ctx, imageKey, ops)
+	if err != nil {
+		transformErrorJSON(w, err)
+		return
+	}
+
+	writeTransformed(w, r, imageKey, transformed)
+}
+
+func transformErrorJSON(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		ErrorJSON(w, http.StatusNotFound, "image not found")
+	case processing.IsPermanent(err):
+		// The image can't be decoded or is over the limits
+		ErrorJSON(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		ErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("failed to transform image: %v", err))
+	}
+}
+
+func writeTransformed(w http.ResponseWriter, r *http.Request, imageKey string, transformed *processing.Transformed) {
+	w.Header().Set("Content-Type", processing.ContentType(transformed.Extension))
+	w.Header().Set("Content-Length", strconv.Itoa(len(transformed.Data)))
+	w.Header().Set("Cache-Control", transformedCacheControl(r.URL))
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(transformed.Data); err != nil {
+		log.Printf("Error writing transformed image %s: %v", imageKey, err)
+	}
+}
+
+// maxTransformedAge is how long a transformed image can be cached, the same key and parameters always give the same
+// image
+const maxTransformedAge = 365 * 24 * time.Hour
+
+// transformedCacheControl returns the Cache-Control of a transformed image. A signed URL stops working when it
+// expires, so its response is private and isn't cached past the expiry.
+func transformedCacheControl(u *url.URL) string {
+	expires, signed := signedurl.Expiry(u)
+	if !signed {
+		return fmt.Sprintf("public, max-age=%d, immutable", int(maxTransformedAge.Seconds()))
+	}
+
+	maxAge := min(max(time.Until(expires), 0), maxTransformedAge)
+	return fmt.Sprintf("private, max-age=%d", int(maxAge.Seconds()))
+}
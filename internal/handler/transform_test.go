@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/signedurl"
+)
+
+func TestTransformedCacheControl(t *testing.T) {
+	signer, err := signedurl.NewSigner([]signedurl.Key{{ID: "k1", Secret: []byte("secret")}}, time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewSigner() = %v", err)
+	}
+	path := "/api/v1/image/photo.png/transform"
+	query := url.Values{"w": {"100"}}
+
+	tests := []struct {
+		name string
+		url  string
+		// The expiry of a signed URL is in seconds, so its max age can be a second shorter
+		want []string
+	}{
+		{"unsigned", path + "?w=100", []string{"public, max-age=31536000, immutable"}},
+		{"signed", signer.Sign(path, query, time.Now().Add(10*time.Minute)), []string{"private, max-age=599", "private, max-age=598"}},
+		{"expired", signer.Sign(path, query, time.Now().Add(-time.Minute)), []string{"private, max-age=0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatalf("url.Parse(%q) = %v", tt.url, err)
+			}
+
+			if got := transformedCacheControl(u); !slices.Contains(tt.want, got) {
+				t.Errorf("transformedCacheControl() = %q, want one of %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/auth"
+)
+
+// AdminAuth only lets through the requests with one of the admin keys as a bearer token, e.g.
+// "Authorization: Bearer <key>", as admin callers. Without keys, the admin API is disabled and every request is
+// rejected.
+func AdminAuth(keys []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(keys) == 0 {
+				http.Error(w, "admin API is disabled", http.StatusForbidden)
+				return
+			}
+
+			token, ok := bearerToken(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "missing admin key", http.StatusUnauthorized)
+				return
+			}
+			if !matchesKey(token, keys) {
+				http.Error(w, "invalid admin key", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithCaller(r.Context(), auth.Caller{Admin: true})))
+		})
+	}
+}
+
+// bearerToken returns the token of the Authorization header of the request
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// matchesKey compares the token with every key in constant time
+func matchesKey(token string, keys []string) bool {
+	matched := 0
+	for _, key := range keys {
+		matched |= subtle.ConstantTimeCompare([]byte(token), []byte(key))
+	}
+	return matched == 1
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/auth"
+)
+
+// Authenticate identifies the caller of the requests with a bearer token, e.g. "Authorization: Bearer <key>": one of
+// the API keys or of the admin keys. Requests without a token go through as anonymous callers, the handlers decide
+// what they can do, and requests with an unknown token are rejected.
+func Authenticate(keys *auth.Keys, adminKeys []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, ok := bearerToken(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "invalid authorization header", http.StatusUnauthorized)
+				return
+			}
+
+			var caller auth.Caller
+			if len(adminKeys) > 0 && matchesKey(token, adminKeys) {
+				caller.Admin = true
+			} else if caller.Owner, ok = keys.Lookup(token); !ok {
+				http.Error(w, "invalid API key", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithCaller(r.Context(), caller)))
+		})
+	}
+}
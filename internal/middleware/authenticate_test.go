@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/auth"
+)
+
+func TestAuthenticate(t *testing.T) {
+	keys, err := auth.ParseKeys([]string{"acme:client-key"})
+	if err != nil {
+		t.Fatalf("ParseKeys() = %v", err)
+	}
+
+	var caller auth.Caller
+	handler := Authenticate(keys, []string{"admin-key"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller = auth.CallerFrom(r.Context())
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantCaller    auth.Caller
+	}{
+		{"anonymous", "", http.StatusOK, auth.Caller{}},
+		{"client", "Bearer client-key", http.StatusOK, auth.Caller{Owner: "acme"}},
+		{"admin", "Bearer admin-key", http.StatusOK, auth.Caller{Admin: true}},
+		{"unknown key", "Bearer other-key", http.StatusForbidden, auth.Caller{}},
+		{"other scheme", "Basic client-key", http.StatusUnauthorized, auth.Caller{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller = auth.Caller{}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if caller != tt.wantCaller {
+				t.Errorf("caller = %+v, want %+v", caller, tt.wantCaller)
+			}
+		})
+	}
+}
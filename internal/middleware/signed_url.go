@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/signedurl"
+)
+
+// SignedURL rejects the requests whose URL isn't signed by the signer or has expired. Without a signer, signing
+// is disabled and every request passes.
+func SignedURL(signer *signedurl.Signer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if signer == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := signer.Verify(r.URL); err != nil {
+				status := http.StatusForbidden
+				if errors.Is(err, signedurl.ErrMissingSignature) {
+					status = http.StatusUnauthorized
+				}
+				http.Error(w, err.Error(), status)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,6 +1,13 @@
 package model
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
 
 // TaskStatus represents the current state of an image processing task.
 type TaskStatus string
@@ -10,27 +17,258 @@ const (
 	StatusProcessing TaskStatus = "processing"
 	StatusCompleted  TaskStatus = "completed"
 	StatusFailed     TaskStatus = "failed"
+	// StatusDeadLetter is set when a task keeps failing with transient errors and runs out of attempts
+	StatusDeadLetter TaskStatus = "dead_letter"
+	// StatusCancelled is set when a pending or processing task is cancelled by the user
+	StatusCancelled TaskStatus = "cancelled"
+)
+
+// TerminalStatuses lists the statuses a task doesn't leave on its own.
+var TerminalStatuses = []TaskStatus{StatusCompleted, StatusFailed, StatusDeadLetter, StatusCancelled}
+
+// IsTerminal reports whether the task is done, successfully or not.
+func (s TaskStatus) IsTerminal() bool {
+	for _, status := range TerminalStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// TaskPriority orders pending tasks, higher values are claimed first.
+type TaskPriority int16
+
+const (
+	PriorityLow    TaskPriority = 0
+	PriorityNormal TaskPriority = 1
+	PriorityHigh   TaskPriority = 2
+)
+
+// Priorities lists the priority levels from the highest to the lowest.
+var Priorities = []TaskPriority{PriorityHigh, PriorityNormal, PriorityLow}
+
+var priorityNames = map[TaskPriority]string{
+	PriorityLow:    "low",
+	PriorityNormal: "normal",
+	PriorityHigh:   "high",
+}
+
+func (p TaskPriority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return strconv.Itoa(int(p))
+}
+
+// ParsePriority returns the priority with the given name.
+func ParsePriority(name string) (TaskPriority, error) {
+	for priority, priorityName := range priorityNames {
+		if priorityName == name {
+			return priority, nil
+		}
+	}
+	return 0, fmt.Errorf("model: unknown priority %q", name)
+}
+
+// OperationType identifies a single step of the processing pipeline.
+type OperationType string
+
+const (
+	OpResize    OperationType = "resize"
+	OpCrop      OperationType = "crop"
+	OpRotate    OperationType = "rotate"
+	OpFlip      OperationType = "flip"
+	OpBlur      OperationType = "blur"
+	OpSharpen   OperationType = "sharpen"
+	OpGrayscale OperationType = "grayscale"
+	OpFormat    OperationType = "format"
+	OpQuality   OperationType = "quality"
+	// OpEncode sets the encoder options of the output, see Operation
+	OpEncode OperationType = "encode"
 )
 
+// Operation is one step of a processing pipeline. Only the fields relevant to the operation type are used.
+type Operation struct {
+	Type      OperationType `json:"type" validate:"required,oneof=resize crop rotate flip blur sharpen grayscale format quality encode"`
+	Width     int           `json:"width,omitempty" validate:"required_if=Type crop,gte=0,lte=10000"`
+	Height    int           `json:"height,omitempty" validate:"required_if=Type crop,gte=0,lte=10000"`
+	X         int           `json:"x,omitempty" validate:"gte=0"`
+	Y         int           `json:"y,omitempty" validate:"gte=0"`
+	Fit       string        `json:"fit,omitempty" validate:"omitempty,oneof=fit fill"`
+	Anchor    string        `json:"anchor,omitempty" validate:"omitempty,oneof=center top bottom left right top_left top_right bottom_left bottom_right"`
+	Angle     float64       `json:"angle,omitempty" validate:"gte=-360,lte=360"`
+	Direction string        `json:"direction,omitempty" validate:"required_if=Type flip,omitempty,oneof=horizontal vertical"`
+	Sigma     float64       `json:"sigma,omitempty" validate:"required_if=Type blur,required_if=Type sharpen,gte=0,lte=100"`
+	Format    string        `json:"format,omitempty" validate:"required_if=Type format,omitempty,oneof=jpeg jpg png gif tiff bmp webp avif"`
+	Quality   int           `json:"quality,omitempty" validate:"required_if=Type quality,gte=0,lte=100"`
+
+	// Encoder options of the encode operation, each one only applies to some formats
+	Progressive bool   `json:"progressive,omitempty"`
+	Subsampling string `json:"subsampling,omitempty" validate:"omitempty,oneof=420 422 444"`
+	Compression string `json:"compression,omitempty" validate:"omitempty,oneof=default none fast best"`
+	Colors      int    `json:"colors,omitempty" validate:"omitempty,gte=2,lte=256"`
+	Dither      bool   `json:"dither,omitempty"`
+	Lossless    bool   `json:"lossless,omitempty"`
+}
+
+// Operations is an ordered list of pipeline steps stored as JSON.
+type Operations []Operation
+
+func (o Operations) Value() (driver.Value, error) {
+	if o == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(o)
+}
+
+func (o *Operations) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*o = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("model: unsupported type for operations")
+	}
+	return json.Unmarshal(data, o)
+}
+
+// Tags are free-form labels of a task stored as JSON.
+type Tags []string
+
+func (t Tags) Value() (driver.Value, error) {
+	if t == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(t)
+}
+
+func (t *Tags) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("model: unsupported type for tags")
+	}
+	return json.Unmarshal(data, t)
+}
+
+// DefaultRenditionName is the name of the single output of a task that doesn't declare renditions.
+const DefaultRenditionName = "default"
+
+// Rendition is a named output variant of a task, produced by running its operations on the decoded original.
+type Rendition struct {
+	ID         int64      `db:"id" json:"-"`
+	TaskID     int64      `db:"task_id" json:"-"`
+	Name       string     `db:"name" json:"name" validate:"required,max=64,excludesall=/\\ "`
+	Operations Operations `db:"operations" json:"operations" validate:"max=32,dive"`
+	// MaxBytes limits the size of the output, the quality and then the dimensions are lowered until it fits
+	MaxBytes int64 `db:"max_bytes" json:"max_bytes,omitempty" validate:"gte=0"`
+	// MinSSIM makes the encoder use the lowest quality whose output keeps this structural similarity to the image
+	MinSSIM   float64   `db:"min_ssim" json:"min_ssim,omitempty" validate:"gte=0,lt=1"`
+	CreatedAt time.Time `db:"created_at" json:"-"`
+}
+
 type ImageProcessingTask struct {
-	ID               int64      `db:"id"`
-	OriginalFilename string     `db:"original_filename"`
-	StorageKey       string     `db:"storage_key"`
-	Status           TaskStatus `db:"status"`
-	ErrorMessage     string     `db:"error_message"`
-	CreatedAt        time.Time  `db:"created_at"`
-	UpdatedAt        time.Time  `db:"updated_at"`
+	ID               int64        `db:"id"`
+	OriginalFilename string       `db:"original_filename"`
+	StorageKey       string       `db:"storage_key"`
+	Status           TaskStatus   `db:"status"`
+	ErrorMessage     string       `db:"error_message"`
+	Operations       Operations   `db:"operations"`
+	Priority         TaskPriority `db:"priority"`
+	ClaimedBy        string       `db:"claimed_by"`
+	LeaseExpiresAt   *time.Time   `db:"lease_expires_at"`
+	Attempts         int          `db:"attempts"`
+	NextAttemptAt    *time.Time   `db:"next_attempt_at"`
+	// CallbackURL receives a webhook when the task reaches a terminal status
+	CallbackURL string `db:"callback_url"`
+	// Owner identifies the customer the task belongs to, Tags label it for searches
+	Owner     string    `db:"owner"`
+	Tags      Tags      `db:"tags"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
 
 	// Relation
-	processedImage *ProcessedImage
+	Renditions      []Rendition      `db:"-"`
+	ProcessedImages []ProcessedImage `db:"-"`
 }
 
 type ProcessedImage struct {
-	ID         int64     `db:"id"`
-	TaskID     int64     `db:"task_id"`
-	Format     string    `db:"format"`
-	Size       string    `db:"size"`
+	ID        int64  `db:"id"`
+	TaskID    int64  `db:"task_id"`
+	Rendition string `db:"rendition"`
+	Format    string `db:"format"`
+	Size      string `db:"size"`
+	Width     int    `db:"width"`
+	Height    int    `db:"height"`
+	// ByteSize is the size of the encoded image
+	ByteSize int64 `db:"byte_size"`
+	// Quality is the quality the image was encoded with, 0 for lossless formats
+	Quality int `db:"quality"`
+	// SSIM is the structural similarity of the image with the unencoded output, 0 if it wasn't measured
+	SSIM       float64   `db:"ssim"`
 	StorageKey string    `db:"storage_key"`
 	CreatedAt  time.Time `db:"created_at"`
 	UpdatedAt  time.Time `db:"updated_at"`
 }
+
+// DeliveryStatus represents the state of a webhook delivery.
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryFailed is set when the receiver kept failing and the delivery ran out of attempts
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// WebhookDelivery notifies the callback URL of a task that it reached a terminal status. The row is kept as a
+// log of the delivery: the last payload sent, the response status and error, and the number of attempts.
+type WebhookDelivery struct {
+	ID             int64           `db:"id"`
+	TaskID         int64           `db:"task_id"`
+	URL            string          `db:"url"`
+	Event          TaskStatus      `db:"event"`
+	Status         DeliveryStatus  `db:"status"`
+	Payload        json.RawMessage `db:"payload"`
+	Attempts       int             `db:"attempts"`
+	ResponseStatus int             `db:"response_status"`
+	LastError      string          `db:"last_error"`
+	NextAttemptAt  time.Time       `db:"next_attempt_at"`
+	CreatedAt      time.Time       `db:"created_at"`
+	UpdatedAt      time.Time       `db:"updated_at"`
+}
+
+// OutboxEventType is the type of the task lifecycle events published through the outbox.
+type OutboxEventType string
+
+const (
+	EventTaskCreated       OutboxEventType = "task.created"
+	EventTaskStatusChanged OutboxEventType = "task.status_changed"
+	// EventImageProcessed is written for every stored output of a task
+	EventImageProcessed OutboxEventType = "image.processed"
+)
+
+// OutboxEvent is written in the same transaction as the change it describes and later published by the relay,
+// so an event is published if and only if its change was committed.
+type OutboxEvent struct {
+	ID int64 `db:"id" json:"id"`
+	// AggregateID is the ID of the task the event is about
+	AggregateID int64           `db:"aggregate_id" json:"task_id"`
+	Type        OutboxEventType `db:"event_type" json:"type"`
+	Payload     json.RawMessage `db:"payload" json:"payload"`
+	CreatedAt   time.Time       `db:"created_at" json:"created_at"`
+	PublishedAt *time.Time      `db:"published_at" json:"-"`
+}
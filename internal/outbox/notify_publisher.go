@@ -0,0 +1,50 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+)
+
+// maxNotifyPayload is the largest payload PostgreSQL accepts for a notification
+const maxNotifyPayload = 8000
+
+// NotifyPublisher sends every event as a JSON notification on a PostgreSQL channel, which other services
+// receive with LISTEN <channel>. Notifications are only delivered to the sessions listening at that time.
+type NotifyPublisher struct {
+	db      *sqlx.DB
+	channel string
+}
+
+var _ EventPublisher = (*NotifyPublisher)(nil)
+
+func NewNotifyPublisher(db *sqlx.DB, channel string) *NotifyPublisher {
+	return &NotifyPublisher{db: db, channel: channel}
+}
+
+func (p *NotifyPublisher) Publish(ctx context.Context, event model.OutboxEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox event %d: %w", event.ID, err)
+	}
+
+	// An event that can never be sent would block the outbox, so it's sent without its payload instead.
+	// Listeners can still read the task from the API.
+	if len(data) > maxNotifyPayload {
+		log.Printf("Warning: outbox event %d is too large for a notification, sending it without its payload", event.ID)
+		event.Payload = nil
+		if data, err = json.Marshal(event); err != nil {
+			return fmt.Errorf("failed to encode outbox event %d: %w", event.ID, err)
+		}
+	}
+
+	if _, err = p.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, p.channel, string(data)); err != nil {
+		return fmt.Errorf("failed to notify outbox event %d: %w", event.ID, err)
+	}
+
+	return nil
+}
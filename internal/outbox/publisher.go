@@ -0,0 +1,82 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+)
+
+// EventPublisher delivers the outbox events to the rest of the stack. Publish returns once the event is handed
+// over, an error makes the relay try the same event again later. Events are published at least once.
+type EventPublisher interface {
+	Publish(ctx context.Context, event model.OutboxEvent) error
+}
+
+// ErrNoSubscribers is returned by MemoryPublisher.Publish when nothing subscribes, so the relay keeps the events
+// in the outbox instead of dropping them
+var ErrNoSubscribers = errors.New("outbox: no subscribers")
+
+// MemoryPublisher hands the events to subscribers in the same process, e.g. in tests or for a consumer that runs
+// alongside the API. Publish waits for every subscriber to accept the event, and fails without subscribers.
+type MemoryPublisher struct {
+	mu          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	events chan model.OutboxEvent
+	// done is closed when the subscriber unsubscribes, so Publish doesn't wait for it anymore
+	done chan struct{}
+}
+
+var _ EventPublisher = (*MemoryPublisher)(nil)
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{subscribers: make(map[*subscriber]struct{})}
+}
+
+// Subscribe returns the events published from now on until the returned function is called.
+func (p *MemoryPublisher) Subscribe(buffer int) (<-chan model.OutboxEvent, func()) {
+	sub := &subscriber{events: make(chan model.OutboxEvent, buffer), done: make(chan struct{})}
+
+	p.mu.Lock()
+	p.subscribers[sub] = struct{}{}
+	p.mu.Unlock()
+
+	var once sync.Once
+	return sub.events, func() {
+		once.Do(func() {
+			p.mu.Lock()
+			delete(p.subscribers, sub)
+			p.mu.Unlock()
+			close(sub.done)
+		})
+	}
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, event model.OutboxEvent) error {
+	// Send outside the lock, a subscriber that is slow to receive must still be able to unsubscribe
+	p.mu.RLock()
+	subscribers := make([]*subscriber, 0, len(p.subscribers))
+	for sub := range p.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	p.mu.RUnlock()
+
+	if len(subscribers) == 0 {
+		return ErrNoSubscribers
+	}
+
+	for _, sub := range subscribers {
+		select {
+		case sub.events <- event:
+		case <-sub.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+)
+
+func TestMemoryPublisherWithoutSubscribers(t *testing.T) {
+	p := NewMemoryPublisher()
+
+	if err := p.Publish(context.Background(), model.OutboxEvent{ID: 1}); !errors.Is(err, ErrNoSubscribers) {
+		t.Fatalf("Publish() = %v, want %v", err, ErrNoSubscribers)
+	}
+}
+
+func TestMemoryPublisherDelivers(t *testing.T) {
+	p := NewMemoryPublisher()
+	events, unsubscribe := p.Subscribe(1)
+	defer unsubscribe()
+
+	if err := p.Publish(context.Background(), model.OutboxEvent{ID: 7}); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	if event := <-events; event.ID != 7 {
+		t.Errorf("received event %d, want 7", event.ID)
+	}
+}
+
+func TestMemoryPublisherSlowSubscriberCanUnsubscribe(t *testing.T) {
+	p := NewMemoryPublisher()
+	_, unsubscribe := p.Subscribe(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	published := make(chan error, 1)
+	go func() {
+		// Blocks, nothing receives
+		published <- p.Publish(ctx, model.OutboxEvent{ID: 1})
+	}()
+
+	// Give Publish time to block on the subscriber
+	time.Sleep(50 * time.Millisecond)
+
+	unsubscribed := make(chan struct{})
+	go func() {
+		unsubscribe()
+		close(unsubscribed)
+	}()
+
+	select {
+	case <-unsubscribed:
+	case <-time.After(time.Second):
+		t.Fatal("unsubscribe is blocked by Publish")
+	}
+
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Errorf("Publish() = %v, want nil once the subscriber is gone", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Publish still waits for a subscriber that unsubscribed")
+	}
+}
@@ -0,0 +1,142 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
+)
+
+type RelayConfig struct {
+	PollingInterval time.Duration
+	BatchSize       int
+	// Retention is how long published events are kept, 0 keeps them forever
+	Retention time.Duration
+}
+
+// cleanupInterval is how often the published events older than the retention are deleted
+const cleanupInterval = time.Hour
+
+// Relay drains the outbox table to an EventPublisher. The events are written by the repository in the same
+// transaction as the changes they describe, so the relay publishes exactly the committed changes, at least once.
+// A single relay publishes them in the order they were written, concurrent relays of several instances may
+// interleave their batches.
+type Relay struct {
+	repo      repository.Repository
+	publisher EventPublisher
+	config    RelayConfig
+
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewRelay(repo repository.Repository, publisher EventPublisher, config RelayConfig) *Relay {
+	if config.PollingInterval <= 0 {
+		config.PollingInterval = time.Second
+		log.Printf("Warning: outbox PollingInterval not set or invalid, defaulting to %s", config.PollingInterval)
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+		log.Printf("Warning: outbox BatchSize not set or invalid, defaulting to %d", config.BatchSize)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Relay{
+		repo:      repo,
+		publisher: publisher,
+		config:    config,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+func (r *Relay) Start() {
+	r.wg.Add(1)
+	go r.loop()
+
+	log.Printf("Outbox relay started, polling every %s", r.config.PollingInterval)
+}
+
+// Stop stops the relay after the batch being published. The events that weren't published yet are published
+// on the next start.
+func (r *Relay) Stop(ctx context.Context) {
+	r.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("Outbox relay stopped.")
+	case <-ctx.Done():
+		log.Println("Outbox relay shutdown context timed out. Some events may be published again.")
+	}
+}
+
+func (r *Relay) loop() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.config.PollingInterval)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			log.Println("Outbox relay exiting.")
+			return
+		case <-ticker.C:
+		}
+
+		// Keep going while full batches are published so a backlog doesn't wait for the ticker
+		for r.relay() {
+		}
+
+		if r.config.Retention > 0 && time.Since(lastCleanup) >= cleanupInterval {
+			lastCleanup = time.Now()
+			r.cleanup()
+		}
+	}
+}
+
+// relay publishes a batch of events and reports whether the batch was full.
+func (r *Relay) relay() bool {
+	ctx, cancel := context.WithTimeout(r.ctx, 30*time.Second)
+	defer cancel()
+
+	published, err := r.repo.PublishOutboxEvents(ctx, r.config.BatchSize, func(event model.OutboxEvent) error {
+		return r.publisher.Publish(ctx, event)
+	})
+	if err != nil {
+		if r.ctx.Err() == nil {
+			log.Printf("Outbox relay failed after publishing %d events: %v", published, err)
+		}
+		return false
+	}
+
+	return published == r.config.BatchSize
+}
+
+func (r *Relay) cleanup() {
+	ctx, cancel := context.WithTimeout(r.ctx, time.Minute)
+	defer cancel()
+
+	deleted, err := r.repo.DeletePublishedOutboxEvents(ctx, r.config.Retention)
+	if err != nil {
+		log.Printf("Outbox relay failed to delete published events: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Outbox relay deleted %d published events older than %s", deleted, r.config.Retention)
+	}
+}
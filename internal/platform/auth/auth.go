@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+)
+
+// Caller is the authenticated client of a request. The zero Caller is an anonymous client.
+type Caller struct {
+	// Owner is the owner of the tasks the client creates and can access
+	Owner string
+	// Admin is set for the clients with an admin key, they can access the tasks of every owner
+	Admin bool
+}
+
+// CanAccess reports whether the caller can access the tasks of the owner. Tasks without an owner are only
+// accessible to admins.
+func (c Caller) CanAccess(owner string) bool {
+	return c.Admin || (c.Owner != "" && c.Owner == owner)
+}
+
+type callerKey struct{}
+
+// WithCaller returns a copy of the context that carries the caller.
+func WithCaller(ctx context.Context, caller Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFrom returns the caller of the context, the anonymous caller if there is none.
+func CallerFrom(ctx context.Context) Caller {
+	caller, _ := ctx.Value(callerKey{}).(Caller)
+	return caller
+}
+
+type apiKey struct {
+	owner string
+	key   []byte
+}
+
+// Keys are the API keys of the clients, each one identifies the owner of the tasks of a client.
+type Keys struct {
+	keys []apiKey
+}
+
+// ParseKeys parses a list of owner:key pairs.
+func ParseKeys(pairs []string) (*Keys, error) {
+	keys := &Keys{keys: make([]apiKey, 0, len(pairs))}
+	seen := make(map[string]bool, len(pairs))
+	for _, pair := range pairs {
+		owner, key, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found || owner == "" || key == "" {
+			return nil, fmt.Errorf("API key %q is not an owner:key pair", pair)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate API key of owner %q", owner)
+		}
+		seen[key] = true
+		keys.keys = append(keys.keys, apiKey{owner: owner, key: []byte(key)})
+	}
+
+	return keys, nil
+}
+
+// Lookup returns the owner of the key. Every key is compared in constant time, so the time taken doesn't tell which
+// keys exist.
+func (k *Keys) Lookup(key string) (string, bool) {
+	if k == nil {
+		return "", false
+	}
+
+	owner := ""
+	for _, candidate := range k.keys {
+		if subtle.ConstantTimeCompare([]byte(key), candidate.key) == 1 {
+			owner = candidate.owner
+		}
+	}
+
+	return owner, owner != ""
+}
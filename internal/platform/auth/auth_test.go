@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys([]string{"acme:secret-1", " globex:secret-2 "})
+	if err != nil {
+		t.Fatalf("ParseKeys() = %v", err)
+	}
+
+	tests := []struct {
+		key       string
+		wantOwner string
+		wantOK    bool
+	}{
+		{"secret-1", "acme", true},
+		{"secret-2", "globex", true},
+		{"secret-3", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		owner, ok := keys.Lookup(tt.key)
+		if owner != tt.wantOwner || ok != tt.wantOK {
+			t.Errorf("Lookup(%q) = %q, %v, want %q, %v", tt.key, owner, ok, tt.wantOwner, tt.wantOK)
+		}
+	}
+}
+
+func TestParseKeysRejectsInvalidPairs(t *testing.T) {
+	for _, pairs := range [][]string{{"acme"}, {":secret"}, {"acme:"}, {"acme:secret", "globex:secret"}} {
+		if _, err := ParseKeys(pairs); err == nil {
+			t.Errorf("ParseKeys(%q) succeeded, want an error", pairs)
+		}
+	}
+}
+
+func TestNilKeys(t *testing.T) {
+	var keys *Keys
+	if _, ok := keys.Lookup("secret"); ok {
+		t.Error("Lookup() of nil keys succeeded")
+	}
+}
+
+func TestCallerCanAccess(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller Caller
+		owner  string
+		want   bool
+	}{
+		{"owner", Caller{Owner: "acme"}, "acme", true},
+		{"other owner", Caller{Owner: "acme"}, "globex", false},
+		{"task without owner", Caller{Owner: "acme"}, "", false},
+		{"anonymous", Caller{}, "", false},
+		{"admin", Caller{Admin: true}, "globex", true},
+	}
+	for _, tt := range tests {
+		if got := tt.caller.CanAccess(tt.owner); got != tt.want {
+			t.Errorf("%s: CanAccess(%q) = %v, want %v", tt.name, tt.owner, got, tt.want)
+		}
+	}
+}
+
+func TestCallerFrom(t *testing.T) {
+	if caller := CallerFrom(context.Background()); caller != (Caller{}) {
+		t.Errorf("CallerFrom() without a caller = %+v, want the anonymous caller", caller)
+	}
+
+	ctx := WithCaller(context.Background(), Caller{Owner: "acme"})
+	if caller := CallerFrom(ctx); caller.Owner != "acme" {
+		t.Errorf("CallerFrom() = %+v, want the owner acme", caller)
+	}
+}
@@ -0,0 +1,166 @@
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query parameters added to a signed URL
+const (
+	ParamExpires   = "exp"
+	ParamKeyID     = "kid"
+	ParamSignature = "sig"
+)
+
+var (
+	ErrMissingSignature = errors.New("missing signature")
+	ErrExpired          = errors.New("signed URL expired")
+	ErrUnknownKey       = errors.New("unknown signing key")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// Key is a signing secret identified by its ID, which is sent with the signature so the secret can be rotated.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseKeys parses a list of id:secret pairs.
+func ParseKeys(pairs []string) ([]Key, error) {
+	keys := make([]Key, 0, len(pairs))
+	seen := make(map[string]bool, len(pairs))
+	for _, pair := range pairs {
+		id, secret, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found || id == "" || secret == "" {
+			return nil, fmt.Errorf("signed URL key %q is not an id:secret pair", pair)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate signed URL key ID %q", id)
+		}
+		seen[id] = true
+		keys = append(keys, Key{ID: id, Secret: []byte(secret)})
+	}
+
+	return keys, nil
+}
+
+// Signer signs URLs with the first of its keys and verifies them with any of them. A key is rotated by adding the
+// new one in front, and removing the old one once the URLs it signed have expired.
+type Signer struct {
+	keys   []Key
+	byID   map[string][]byte
+	ttl    time.Duration
+	maxTTL time.Duration
+}
+
+// NewSigner returns a signer whose URLs expire after ttl by default and after maxTTL at most.
+func NewSigner(keys []Key, ttl time.Duration, maxTTL time.Duration) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+	if ttl <= 0 || maxTTL < ttl {
+		return nil, fmt.Errorf("invalid signed URL expiry %s (max %s)", ttl, maxTTL)
+	}
+
+	byID := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		byID[key.ID] = key.Secret
+	}
+
+	return &Signer{keys: keys, byID: byID, ttl: ttl, maxTTL: maxTTL}, nil
+}
+
+// TTL returns the default lifetime of the signed URLs.
+func (s *Signer) TTL() time.Duration {
+	return s.ttl
+}
+
+// MaxTTL returns the longest lifetime a signed URL can have.
+func (s *Signer) MaxTTL() time.Duration {
+	return s.maxTTL
+}
+
+// Sign returns the escaped path with its query and the signature parameters, valid until expires. The path is given
+// unescaped. The signature covers the path, every query parameter and the expiry, so none of them can be changed.
+func (s *Signer) Sign(path string, query url.Values, expires time.Time) string {
+	key := s.keys[0]
+
+	signed := url.Values{}
+	for name, values := range query {
+		signed[name] = values
+	}
+	signed.Set(ParamExpires, strconv.FormatInt(expires.Unix(), 10))
+	signed.Set(ParamKeyID, key.ID)
+	signed.Set(ParamSignature, sign(key.Secret, path, signed))
+
+	return (&url.URL{Path: path, RawQuery: signed.Encode()}).String()
+}
+
+// Verify checks the signature parameters of a request URL.
+func (s *Signer) Verify(u *url.URL) error {
+	query := u.Query()
+
+	signature := query.Get(ParamSignature)
+	if signature == "" {
+		return ErrMissingSignature
+	}
+
+	expires, err := strconv.ParseInt(query.Get(ParamExpires), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if time.Now().Unix() > expires {
+		return ErrExpired
+	}
+
+	secret, ok := s.byID[query.Get(ParamKeyID)]
+	if !ok {
+		return ErrUnknownKey
+	}
+
+	if !hmac.Equal([]byte(sign(secret, u.Path, query)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Expiry returns when a signed URL expires, and false when the URL isn't signed. It doesn't verify the signature.
+func Expiry(u *url.URL) (time.Time, bool) {
+	query := u.Query()
+	if query.Get(ParamSignature) == "" {
+		return time.Time{}, false
+	}
+
+	expires, err := strconv.ParseInt(query.Get(ParamExpires), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(expires, 0), true
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the path and the encoded query without the signature. Encode sorts
+// the parameters, so their order in the URL doesn't matter.
+func sign(secret []byte, path string, query url.Values) string {
+	unsigned := url.Values{}
+	for name, values := range query {
+		if name != ParamSignature {
+			unsigned[name] = values
+		}
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(path))
+	mac.Write([]byte("?"))
+	mac.Write([]byte(unsigned.Encode()))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,147 @@
+package signedurl
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSigner(t *testing.T, keys ...Key) *Signer {
+	t.Helper()
+
+	signer, err := NewSigner(keys, time.Hour, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("NewSigner() = %v", err)
+	}
+	return signer
+}
+
+func TestVerify(t *testing.T) {
+	oldKey := Key{ID: "old", Secret: []byte("old secret")}
+	newKey := Key{ID: "new", Secret: []byte("new secret")}
+
+	path := "/api/v1/image/photo 1.png/transform"
+	query := url.Values{"w": {"100"}, "fmt": {"webp"}}
+	inAnHour := time.Now().Add(time.Hour)
+
+	signer := newTestSigner(t, oldKey)
+	// The old key is kept after the new one is added in front, until the URLs it signed expire
+	rotated := newTestSigner(t, newKey, oldKey)
+	// The old key was removed
+	replaced := newTestSigner(t, newKey)
+
+	tests := []struct {
+		name     string
+		signer   *Signer
+		verifier *Signer
+		url      string
+		// edit changes the signed URL before it's verified
+		edit func(u *url.URL)
+		want error
+	}{
+		{name: "valid", signer: signer, verifier: signer},
+		{name: "signed with the rotated key", signer: rotated, verifier: rotated},
+		{name: "signed with the old key after a rotation", signer: signer, verifier: rotated},
+		{
+			name: "parameters in another order", signer: signer, verifier: signer,
+			edit: func(u *url.URL) {
+				values := strings.Split(u.RawQuery, "&")
+				values[0], values[len(values)-1] = values[len(values)-1], values[0]
+				u.RawQuery = strings.Join(values, "&")
+			},
+		},
+		{name: "unsigned", signer: signer, verifier: signer, url: path + "?w=100", want: ErrMissingSignature},
+		{
+			name: "tampered path", signer: signer, verifier: signer, want: ErrInvalidSignature,
+			edit: func(u *url.URL) { u.Path = strings.Replace(u.Path, "photo 1", "photo 2", 1) },
+		},
+		{
+			name: "tampered query", signer: signer, verifier: signer, want: ErrInvalidSignature,
+			edit: func(u *url.URL) { setQuery(u, "w", "2000") },
+		},
+		{
+			name: "added query parameter", signer: signer, verifier: signer, want: ErrInvalidSignature,
+			edit: func(u *url.URL) { setQuery(u, "q", "10") },
+		},
+		{
+			name: "extended expiry", signer: signer, verifier: signer, want: ErrInvalidSignature,
+			edit: func(u *url.URL) { setQuery(u, ParamExpires, "99999999999") },
+		},
+		{
+			name: "invalid expiry", signer: signer, verifier: signer, want: ErrInvalidSignature,
+			edit: func(u *url.URL) { setQuery(u, ParamExpires, "tomorrow") },
+		},
+		{
+			name: "expired", signer: signer, verifier: signer, want: ErrExpired,
+			url: signer.Sign(path, query, time.Now().Add(-time.Minute)),
+		},
+		{
+			name: "unknown key ID", signer: signer, verifier: signer, want: ErrUnknownKey,
+			edit: func(u *url.URL) { setQuery(u, ParamKeyID, "other") },
+		},
+		{name: "old key removed", signer: signer, verifier: replaced, want: ErrUnknownKey},
+		{
+			name: "signed with another secret", signer: newTestSigner(t, Key{ID: "old", Secret: []byte("guess")}),
+			verifier: signer, want: ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawURL := tt.url
+			if rawURL == "" {
+				rawURL = tt.signer.Sign(path, query, inAnHour)
+			}
+			u, err := url.Parse(rawURL)
+			if err != nil {
+				t.Fatalf("url.Parse(%q) = %v", rawURL, err)
+			}
+			if tt.edit != nil {
+				tt.edit(u)
+			}
+
+			if err = tt.verifier.Verify(u); !errors.Is(err, tt.want) {
+				t.Errorf("Verify(%s) = %v, want %v", u, err, tt.want)
+			}
+		})
+	}
+}
+
+func setQuery(u *url.URL, name string, value string) {
+	query := u.Query()
+	query.Set(name, value)
+	u.RawQuery = query.Encode()
+}
+
+func TestExpiry(t *testing.T) {
+	signer := newTestSigner(t, Key{ID: "k1", Secret: []byte("secret")})
+	expires := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+
+	u, _ := url.Parse(signer.Sign("/api/v1/image/photo.png", nil, expires))
+	if got, ok := Expiry(u); !ok || !got.Equal(expires) {
+		t.Errorf("Expiry(%s) = %s, %t, want %s, true", u, got, ok, expires)
+	}
+
+	u, _ = url.Parse("/api/v1/image/photo.png?exp=1700000000")
+	if _, ok := Expiry(u); ok {
+		t.Errorf("Expiry(%s) is signed, want unsigned", u)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys([]string{"new:s3cret", " old:with:colon "})
+	if err != nil {
+		t.Fatalf("ParseKeys() = %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != "new" || string(keys[0].Secret) != "s3cret" || keys[1].ID != "old" || string(keys[1].Secret) != "with:colon" {
+		t.Errorf("ParseKeys() = %+v", keys)
+	}
+
+	for _, pairs := range [][]string{{"nosecret"}, {":secret"}, {"id:"}, {"k1:a", "k1:b"}} {
+		if _, err = ParseKeys(pairs); err == nil {
+			t.Errorf("ParseKeys(%q) = nil, want an error", pairs)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package validator
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -13,6 +16,18 @@ type Validator struct {
 func New() *Validator {
 	v := validator.New()
 
+	// Report the json names of the fields so the errors match what the client sent
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+
 	return &Validator{validate: v}
 }
 
@@ -20,6 +35,12 @@ func (v *Validator) Validate(i any) error {
 	return v.validate.Struct(i)
 }
 
+// IsValidationError reports whether err was returned by Validate for a struct that failed its rules
+func IsValidationError(err error) bool {
+	var verr validator.ValidationErrors
+	return errors.As(err, &verr)
+}
+
 type ValidationError struct {
 	Field   string `json:"field"`
 	Rule    string `json:"rule"`
@@ -32,7 +53,7 @@ func FormatErrors(err error) []ValidationError {
 	if verr, ok := err.(validator.ValidationErrors); ok {
 		for _, fe := range verr {
 			var elem ValidationError
-			elem.Field = fe.Field()
+			elem.Field = fieldPath(fe)
 			elem.Rule = fe.Tag()
 			elem.Param = fe.Param()
 			elem.Message = formatErrorMessage(fe)
@@ -50,9 +71,30 @@ func formatErrorMessage(fe validator.FieldError) string {
 		return fmt.Sprintf("The %s field must be greater than %s", fe.Field(), fe.Param())
 	case "lt":
 		return fmt.Sprintf("The %s field must be less than %s", fe.Field(), fe.Param())
+	case "gte":
+		return fmt.Sprintf("The %s field must be greater than or equal to %s", fe.Field(), fe.Param())
+	case "lte":
+		return fmt.Sprintf("The %s field must be less than or equal to %s", fe.Field(), fe.Param())
+	case "max":
+		return fmt.Sprintf("The %s field must not exceed %s", fe.Field(), fe.Param())
+	case "required_if":
+		return fmt.Sprintf("The %s field is required when %s", fe.Field(), fe.Param())
+	case "unique":
+		return fmt.Sprintf("The %s field must not contain duplicates", fe.Field())
+	case "http_url":
+		return fmt.Sprintf("The %s field must be an http or https URL", fe.Field())
 	case "oneof":
-		return fmt.Sprintf("The %s filed must be on of [%s]", fe.Field(), fe.Field())
+		return fmt.Sprintf("The %s field must be one of [%s]", fe.Field(), fe.Param())
 	default:
 		return fmt.Sprintf("Field %s failed validation on rule %s", fe.Field(), fe.Tag())
 	}
 }
+
+// fieldPath returns the path of the field without the name of the validated struct, e.g. "operations[0].width"
+func fieldPath(fe validator.FieldError) string {
+	_, path, found := strings.Cut(fe.Namespace(), ".")
+	if !found {
+		return fe.Field()
+	}
+	return path
+}
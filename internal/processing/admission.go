@@ -0,0 +1,118 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// ErrImageTooLarge is returned for images over the configured pixel or dimension limits.
+var ErrImageTooLarge = errors.New("image is too large")
+
+// admit reads the header of an encoded image and reserves the memory its decoding and the plan will need from the
+// decode budget, waiting for other tasks to release theirs if needed. Images over the limits, or plans that would
+// produce one, are rejected before anything is decoded, which also protects against decompression bombs. The
+// returned function releases the reservation and must be called once the images aren't used anymore.
+func (s *Service) admit(ctx context.Context, data []byte, plan *Plan) (image.Config, func(), error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return image.Config{}, nil, fmt.Errorf("failed to read image header: %w", Permanent(err))
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return image.Config{}, nil, fmt.Errorf("invalid %s image dimensions %dx%d: %w", format, cfg.Width, cfg.Height, Permanent(ErrImageTooLarge))
+	}
+	if cfg.Width > s.config.MaxImageDimension || cfg.Height > s.config.MaxImageDimension {
+		return image.Config{}, nil, fmt.Errorf("%s image of %dx%d exceeds the maximum dimension of %d: %w",
+			format, cfg.Width, cfg.Height, s.config.MaxImageDimension, Permanent(ErrImageTooLarge))
+	}
+	if pixels := int64(cfg.Width) * int64(cfg.Height); pixels > s.config.MaxImagePixels {
+		return image.Config{}, nil, fmt.Errorf("%s image of %d pixels exceeds the maximum of %d: %w",
+			format, pixels, s.config.MaxImagePixels, Permanent(ErrImageTooLarge))
+	}
+
+	largest, err := s.checkOutputSizes(plan.Sizes(image.Pt(cfg.Width, cfg.Height)))
+	if err != nil {
+		return image.Config{}, nil, err
+	}
+
+	weight := estimateDecodedBytes(cfg) + estimateOutputBytes(largest)
+	if plan.measuresSSIM(s.config.MinSSIM) {
+		// Without operations the outputs have the size of the image
+		measured := largest
+		if measured == 0 {
+			measured = int64(cfg.Width) * int64(cfg.Height)
+		}
+		weight += estimateSSIMBytes(measured)
+	}
+
+	// A single image can't reserve more than the whole budget, or it would never be admitted
+	weight = min(weight, s.config.MaxDecodeMemory)
+	if err = s.decodeSem.Acquire(ctx, weight); err != nil {
+		return image.Config{}, nil, fmt.Errorf("failed to wait for decode memory: %w", err)
+	}
+
+	return cfg, func() { s.decodeSem.Release(weight) }, nil
+}
+
+// estimateDecodedBytes approximates the memory used while processing an image: the decoded image itself plus
+// the NRGBA copy the operations work on.
+func estimateDecodedBytes(cfg image.Config) int64 {
+	pixels := int64(cfg.Width) * int64(cfg.Height)
+
+	var decoded int64
+	switch cfg.ColorModel {
+	case color.GrayModel, color.AlphaModel:
+		decoded = pixels
+	case color.Gray16Model, color.Alpha16Model:
+		decoded = pixels * 2
+	case color.YCbCrModel:
+		// Worst case 4:4:4 subsampling
+		decoded = pixels * 3
+	case color.RGBA64Model, color.NRGBA64Model:
+		decoded = pixels * 8
+	default:
+		if _, ok := cfg.ColorModel.(color.Palette); ok {
+			decoded = pixels
+		} else {
+			decoded = pixels * 4
+		}
+	}
+
+	return decoded + pixels*4
+}
+
+// checkOutputSizes applies the image limits to the images the operations produce, so a small image can't be scaled
+// up past them. It returns the number of pixels of the largest one.
+func (s *Service) checkOutputSizes(sizes []image.Point) (int64, error) {
+	var largest int64
+	for _, size := range sizes {
+		if size.X > s.config.MaxImageDimension || size.Y > s.config.MaxImageDimension {
+			return 0, fmt.Errorf("operations would produce an image of %dx%d, which exceeds the maximum dimension of %d: %w",
+				size.X, size.Y, s.config.MaxImageDimension, Permanent(ErrImageTooLarge))
+		}
+		pixels := int64(size.X) * int64(size.Y)
+		if pixels > s.config.MaxImagePixels {
+			return 0, fmt.Errorf("operations would produce an image of %d pixels, which exceeds the maximum of %d: %w",
+				pixels, s.config.MaxImagePixels, Permanent(ErrImageTooLarge))
+		}
+		largest = max(largest, pixels)
+	}
+
+	return largest, nil
+}
+
+// estimateOutputBytes approximates the memory the operations need on top of the decoded image: the largest NRGBA
+// image they produce and the one it's produced from.
+func estimateOutputBytes(pixels int64) int64 {
+	return pixels * 4 * 2
+}
+
+// estimateSSIMBytes approximates the memory measuring the SSIM of an output needs: the decoded output, in the worst
+// case as NRGBA, and the luma of both images.
+func estimateSSIMBytes(pixels int64) int64 {
+	return pixels*4 + pixels*2
+}
@@ -0,0 +1,137 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"golang.org/x/sync/semaphore"
+)
+
+func newAdmissionService() *Service {
+	config := ServiceConfig{MaxImagePixels: 50_000_000, MaxImageDimension: 16384, MaxDecodeMemory: 1 << 30}
+	return &Service{config: config, decodeSem: semaphore.NewWeighted(config.MaxDecodeMemory)}
+}
+
+func encodePNGFixture(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("failed to encode fixture: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestAdmitChecksOutputSizes(t *testing.T) {
+	s := newAdmissionService()
+	data := encodePNGFixture(t, 1, 16384)
+
+	tests := []struct {
+		name    string
+		ops     model.Operations
+		wantErr bool
+	}{
+		{"width only", model.Operations{{Type: model.OpResize, Width: 2000}}, true},
+		{"height only", model.Operations{{Type: model.OpResize, Height: 2000}}, false},
+		{"fill cover image", model.Operations{{Type: model.OpResize, Width: 16384, Height: 1, Fit: "fill"}}, true},
+		{"rotate right angle", model.Operations{{Type: model.OpRotate, Angle: 90}}, false},
+		{"rotate", model.Operations{{Type: model.OpRotate, Angle: 45}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := NewPipeline(tt.ops)
+			if err != nil {
+				t.Fatalf("NewPipeline() = %v", err)
+			}
+
+			_, release, err := s.admit(context.Background(), data, &Plan{Base: pipeline})
+			if tt.wantErr {
+				if !errors.Is(err, ErrImageTooLarge) || !IsPermanent(err) {
+					t.Errorf("admit() = %v, want a permanent %v", err, ErrImageTooLarge)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("admit() = %v", err)
+			}
+			release()
+		})
+	}
+}
+
+func TestAdmitReservesOutputMemory(t *testing.T) {
+	s := newAdmissionService()
+	data := encodePNGFixture(t, 100, 100)
+
+	pipeline, err := NewPipeline(model.Operations{{Type: model.OpResize, Width: 1000}})
+	if err != nil {
+		t.Fatalf("NewPipeline() = %v", err)
+	}
+
+	_, release, err := s.admit(context.Background(), data, &Plan{Base: pipeline})
+	if err != nil {
+		t.Fatalf("admit() = %v", err)
+	}
+	defer release()
+
+	// The decoded image and its copy take 100*100*5 bytes, the output and its source 1000*1000*8
+	want := int64(100*100*5 + 1000*1000*8)
+	if s.decodeSem.TryAcquire(s.config.MaxDecodeMemory - want + 1) {
+		t.Errorf("admit() reserved less than %d bytes", want)
+	}
+	if !s.decodeSem.TryAcquire(s.config.MaxDecodeMemory - want) {
+		t.Errorf("admit() reserved more than %d bytes", want)
+	}
+}
+
+func TestAdmitReservesSSIMMemory(t *testing.T) {
+	data := encodePNGFixture(t, 100, 100)
+	resize := model.Operation{Type: model.OpResize, Width: 200}
+
+	// The decoded image and its copy take 100*100*5 bytes and the output and its source 200*200*8, measuring the
+	// output takes another 200*200*6
+	tests := []struct {
+		name           string
+		rendition      model.Rendition
+		defaultMinSSIM float64
+		want           int64
+	}{
+		{"without SSIM", model.Rendition{Operations: model.Operations{resize}}, 0, 100*100*5 + 200*200*8},
+		{"rendition SSIM", model.Rendition{Operations: model.Operations{resize}, MinSSIM: 0.95}, 0, 100*100*5 + 200*200*(8+6)},
+		{"default SSIM", model.Rendition{Operations: model.Operations{resize}}, 0.95, 100*100*5 + 200*200*(8+6)},
+		{"lossless output", model.Rendition{Operations: model.Operations{resize, {Type: model.OpFormat, Format: "png"}}, MinSSIM: 0.95}, 0, 100*100*5 + 200*200*8},
+		{"without operations", model.Rendition{MinSSIM: 0.95}, 0, 100 * 100 * (5 + 6)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newAdmissionService()
+			s.config.MinSSIM = tt.defaultMinSSIM
+
+			tt.rendition.Name = "default"
+			plan, err := NewPlan(&model.ImageProcessingTask{Renditions: []model.Rendition{tt.rendition}})
+			if err != nil {
+				t.Fatalf("NewPlan() = %v", err)
+			}
+
+			_, release, err := s.admit(context.Background(), data, plan)
+			if err != nil {
+				t.Fatalf("admit() = %v", err)
+			}
+			defer release()
+
+			if s.decodeSem.TryAcquire(s.config.MaxDecodeMemory - tt.want + 1) {
+				t.Fatalf("admit() reserved less than %d bytes", tt.want)
+			}
+			if !s.decodeSem.TryAcquire(s.config.MaxDecodeMemory - tt.want) {
+				t.Errorf("admit() reserved more than %d bytes", tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package processing
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// autoscaler keeps the worker pool between WorkerPoolMin and WorkerPoolMax. It grows the pool right away
+// when there is a backlog that the busy workers can't take, and shrinks it one worker at a time once the
+// pool has been underused for ScaleDownDelay, so short gaps in a burst don't make it oscillate.
+func (s *Service) autoscaler() {
+	defer s.loopWG.Done()
+
+	if s.config.WorkerPoolMin == s.config.WorkerPoolMax {
+		// Fixed size pool
+		return
+	}
+
+	ticker := time.NewTicker(s.config.ScaleInterval)
+	defer ticker.Stop()
+
+	var underusedSince time.Time
+
+	for {
+		select {
+		case <-s.loopCtx.Done():
+			log.Println("Autoscaler exiting.")
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(s.loopCtx, 10*time.Second)
+		pending, err := s.repo.CountPendingTasks(ctx)
+		cancel()
+
+		if err != nil {
+			log.Printf("Autoscaler failed to count pending tasks: %v", err)
+			continue
+		}
+
+		workers := int(s.workers.Load())
+		busy := int(s.busyWorkers.Load())
+		backlog := len(s.taskChan) + pending
+
+		// Enough workers for the tasks in progress and the backlog
+		desired := min(max(busy+backlog, s.config.WorkerPoolMin), s.config.WorkerPoolMax)
+
+		switch {
+		case desired > workers:
+			underusedSince = time.Time{}
+			log.Printf("Autoscaler: scaling up from %d to %d workers (busy: %d, backlog: %d)", workers, desired, busy, backlog)
+			for range desired - workers {
+				s.startWorker()
+			}
+			// The new workers can take more tasks than what the poller already claimed
+			s.Wake()
+
+		case desired < workers:
+			if underusedSince.IsZero() {
+				underusedSince = time.Now()
+			}
+			if time.Since(underusedSince) < s.config.ScaleDownDelay {
+				continue
+			}
+
+			// Only an idle worker receives from quitChan, so a busy one is never interrupted
+			select {
+			case s.quitChan <- struct{}{}:
+				log.Printf("Autoscaler: scaled down to %d workers (busy: %d, backlog: %d)", workers-1, busy, backlog)
+			default:
+			}
+
+		default:
+			underusedSince = time.Time{}
+		}
+	}
+}
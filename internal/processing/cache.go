@@ -0,0 +1,85 @@
+package processing
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// lruCache keeps the most recently used transformed images up to a total size in bytes.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value *Transformed
+}
+
+func newLRUCache(capacity int64) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key string) (*Transformed, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+
+	return element.Value.(*lruEntry).value, true
+}
+
+// add stores the value and evicts the least recently used entries over the capacity. Values over an eighth of the
+// capacity aren't stored, so a few large images can't flush the whole cache.
+func (c *lruCache) add(key string, value *Transformed) {
+	size := int64(len(value.Data))
+	if size > c.capacity/8 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+	c.size += size
+
+	for c.size > c.capacity {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*lruEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.size -= int64(len(entry.value.Data))
+	}
+}
+
+// removePrefix removes the entries whose key starts with the prefix
+func (c *lruCache) removePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, element := range c.entries {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		c.order.Remove(element)
+		delete(c.entries, key)
+		c.size -= int64(len(element.Value.(*lruEntry).value.Data))
+	}
+}
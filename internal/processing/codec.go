@@ -0,0 +1,201 @@
+package processing
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	// Registers the WebP decoder with image.Decode, the encoder needs libwebp (see codec_webp.go)
+	_ "golang.org/x/image/webp"
+)
+
+// EncodeOptions are the encoder settings of an output. Encoders ignore the settings that don't apply to them.
+type EncodeOptions struct {
+	// Quality is the 1-100 quality of the lossy formats
+	Quality int
+	// Progressive and Subsampling (420, 422 or 444) apply to JPEG
+	Progressive bool
+	Subsampling string
+	// Compression is the PNG compression level: default, none, fast or best
+	Compression string
+	// Colors quantizes PNG and GIF outputs to a palette of that many colors, optionally with dithering
+	Colors int
+	Dither bool
+	// Lossless applies to WebP
+	Lossless bool
+}
+
+// EncodeFunc writes an image in the format of a codec.
+type EncodeFunc func(w io.Writer, img image.Image, opts EncodeOptions) error
+
+// CheckFunc returns an error if an encoder doesn't support the options.
+type CheckFunc func(opts EncodeOptions) error
+
+// Codec describes an image format. Decoders are registered with image.RegisterFormat, so image.Decode and the
+// admission checks find them, while encoders are looked up here by the pipelines.
+//
+// Formats that need a C library are only encoded or decoded when the binary is built with its build tag, e.g.
+// "libwebp". Their codec is listed either way so a format that isn't available gets a clear error.
+type Codec struct {
+	Name string
+	// Extensions are the file extensions (without the dot) of the format, the first one is used for outputs
+	Extensions  []string
+	ContentType string
+	encode      EncodeFunc
+	check       CheckFunc
+}
+
+// CanEncode reports whether the codec can write images in this build.
+func (c *Codec) CanEncode() bool {
+	return c.encode != nil
+}
+
+// Check returns an error if the codec can't encode with the options in this build.
+func (c *Codec) Check(opts EncodeOptions) error {
+	if c.check == nil {
+		return nil
+	}
+	return c.check(opts)
+}
+
+// Encode writes the image in the format of the codec.
+func (c *Codec) Encode(w io.Writer, img image.Image, opts EncodeOptions) error {
+	return c.encode(w, img, opts)
+}
+
+// lossy reports whether the codec encodes with a quality, given the options
+func (c *Codec) lossy(opts EncodeOptions) bool {
+	switch c.Name {
+	case "jpeg", "avif":
+		return true
+	case "webp":
+		return !opts.Lossless
+	default:
+		return false
+	}
+}
+
+// Extension returns the file extension (without the dot) of the outputs of the codec.
+func (c *Codec) Extension() string {
+	return c.Extensions[0]
+}
+
+var codecs = []*Codec{
+	{Name: "jpeg", Extensions: []string{"jpeg", "jpg"}, ContentType: "image/jpeg", encode: imagingEncoder(imaging.JPEG), check: checkJPEG},
+	{Name: "png", Extensions: []string{"png"}, ContentType: "image/png", encode: encodePNG},
+	{Name: "gif", Extensions: []string{"gif"}, ContentType: "image/gif", encode: encodeGIF},
+	{Name: "tiff", Extensions: []string{"tiff", "tif"}, ContentType: "image/tiff", encode: imagingEncoder(imaging.TIFF)},
+	{Name: "bmp", Extensions: []string{"bmp"}, ContentType: "image/bmp", encode: imagingEncoder(imaging.BMP)},
+	{Name: "webp", Extensions: []string{"webp"}, ContentType: "image/webp"},
+	{Name: "avif", Extensions: []string{"avif"}, ContentType: "image/avif"},
+	{Name: "heic", Extensions: []string{"heic", "heif"}, ContentType: "image/heic"},
+}
+
+// LookupCodec returns the codec with the given name or file extension.
+func LookupCodec(name string) (*Codec, bool) {
+	name = strings.ToLower(strings.TrimPrefix(name, "."))
+	for _, codec := range codecs {
+		if codec.Name == name {
+			return codec, true
+		}
+		for _, extension := range codec.Extensions {
+			if extension == name {
+				return codec, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// ErrUnsupportedFormat is returned by DetectCodec for images the binary can't decode.
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// DetectCodec returns the codec of an image from its header. Formats whose decoder needs a build tag, e.g. HEIC
+// without libheif, are unsupported like any other unknown format.
+func DetectCodec(r io.Reader) (*Codec, error) {
+	_, format, err := image.DecodeConfig(r)
+	if errors.Is(err, image.ErrFormat) {
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	codec, ok := LookupCodec(format)
+	if !ok {
+		return nil, ErrUnsupportedFormat
+	}
+	return codec, nil
+}
+
+// ContentType returns the content type of a file extension, with or without the dot.
+func ContentType(extension string) string {
+	if codec, ok := LookupCodec(extension); ok {
+		return codec.ContentType
+	}
+	return "application/octet-stream"
+}
+
+// setEncoder is called by the init functions of the optional codecs, check may be nil if every option is supported
+func setEncoder(name string, encode EncodeFunc, check CheckFunc) {
+	codec, ok := LookupCodec(name)
+	if !ok {
+		panic("processing: unknown codec " + name)
+	}
+	codec.encode = encode
+	codec.check = check
+}
+
+func imagingEncoder(format imaging.Format) EncodeFunc {
+	return func(w io.Writer, img image.Image, opts EncodeOptions) error {
+		return imaging.Encode(w, img, format, imaging.JPEGQuality(opts.Quality))
+	}
+}
+
+// checkJPEG rejects the options the encoder of the standard library doesn't have, libjpeg supports them all
+func checkJPEG(opts EncodeOptions) error {
+	if opts.Progressive {
+		return errors.New("progressive JPEG needs a build with libjpeg")
+	}
+	if opts.Subsampling != "" && opts.Subsampling != "420" {
+		return fmt.Errorf("JPEG chroma subsampling %s needs a build with libjpeg", opts.Subsampling)
+	}
+	return nil
+}
+
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"":        png.DefaultCompression,
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+func encodePNG(w io.Writer, img image.Image, opts EncodeOptions) error {
+	if opts.Colors > 0 {
+		img = quantize(img, opts.Colors, opts.Dither)
+	}
+
+	encoder := png.Encoder{CompressionLevel: pngCompressionLevels[opts.Compression]}
+	return encoder.Encode(w, img)
+}
+
+func encodeGIF(w io.Writer, img image.Image, opts EncodeOptions) error {
+	colors := opts.Colors
+	if colors == 0 {
+		colors = 256
+	}
+
+	var drawer draw.Drawer = draw.Src
+	if opts.Dither {
+		drawer = draw.FloydSteinberg
+	}
+
+	return gif.Encode(w, img, &gif.Options{NumColors: colors, Quantizer: medianCut{colors: colors}, Drawer: drawer})
+}
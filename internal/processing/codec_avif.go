@@ -0,0 +1,81 @@
+//go:build cgo && libavif
+
+package processing
+
+/*
+#cgo LDFLAGS: -lavif
+#include <avif/avif.h>
+
+// encode_rgba encodes 8-bit non-premultiplied RGBA pixels. The quantizers are used instead of the quality setting
+// of libavif 1.0, so older versions work too.
+static avifResult encode_rgba(uint8_t* pixels, uint32_t width, uint32_t height, uint32_t row_bytes, int quantizer,
+		avifRWData* output) {
+	avifImage* image = avifImageCreate(width, height, 8, AVIF_PIXEL_FORMAT_YUV420);
+	if (image == NULL) {
+		return AVIF_RESULT_UNKNOWN_ERROR;
+	}
+
+	avifRGBImage rgb;
+	avifRGBImageSetDefaults(&rgb, image);
+	rgb.format = AVIF_RGB_FORMAT_RGBA;
+	rgb.depth = 8;
+	rgb.pixels = pixels;
+	rgb.rowBytes = row_bytes;
+
+	avifResult result = avifImageRGBToYUV(image, &rgb);
+	if (result == AVIF_RESULT_OK) {
+		avifEncoder* encoder = avifEncoderCreate();
+		if (encoder == NULL) {
+			result = AVIF_RESULT_UNKNOWN_ERROR;
+		} else {
+			encoder->minQuantizer = quantizer;
+			encoder->maxQuantizer = quantizer;
+			encoder->minQuantizerAlpha = quantizer;
+			encoder->maxQuantizerAlpha = quantizer;
+			encoder->speed = 6;
+			result = avifEncoderWrite(encoder, image, output);
+			avifEncoderDestroy(encoder);
+		}
+	}
+
+	avifImageDestroy(image);
+	return result;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"unsafe"
+
+	"github.com/disintegration/imaging"
+)
+
+func init() {
+	setEncoder("avif", encodeAVIF, nil)
+}
+
+func encodeAVIF(w io.Writer, img image.Image, opts EncodeOptions) error {
+	nrgba := imaging.Clone(img)
+	bounds := nrgba.Bounds()
+	if bounds.Empty() {
+		return errors.New("avif: empty image")
+	}
+
+	// Quality 100 is the lossless quantizer 0, quality 1 is close to the worst one, 63
+	quantizer := (100 - min(max(opts.Quality, 1), 100)) * C.AVIF_QUANTIZER_WORST_QUALITY / 100
+
+	var output C.avifRWData
+	result := C.encode_rgba((*C.uint8_t)(unsafe.Pointer(&nrgba.Pix[0])), C.uint32_t(bounds.Dx()), C.uint32_t(bounds.Dy()),
+		C.uint32_t(nrgba.Stride), C.int(quantizer), &output)
+	if result != C.AVIF_RESULT_OK {
+		return fmt.Errorf("avif: %s", C.GoString(C.avifResultToString(result)))
+	}
+	defer C.avifRWDataFree(&output)
+
+	_, err := w.Write(C.GoBytes(unsafe.Pointer(output.data), C.int(output.size)))
+	return err
+}
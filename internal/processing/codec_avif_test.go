@@ -0,0 +1,32 @@
+//go:build cgo && libavif
+
+package processing
+
+import (
+	"bytes"
+	"testing"
+)
+
+// There is no AVIF decoder, so the outputs are checked by their header and by their size growing with the quality
+func TestAVIFEncode(t *testing.T) {
+	codec, _ := LookupCodec("avif")
+	src := quadrantImage(64, 48)
+
+	sizes := make(map[int]int)
+	for _, quality := range []int{20, 95} {
+		var buf bytes.Buffer
+		if err := codec.Encode(&buf, src, EncodeOptions{Quality: quality}); err != nil {
+			t.Fatalf("Encode(quality %d) = %v", quality, err)
+		}
+
+		data := buf.Bytes()
+		if len(data) < 12 || string(data[4:12]) != "ftypavif" {
+			t.Fatalf("output of quality %d doesn't start with an avif ftyp box: % x", quality, data[:min(len(data), 12)])
+		}
+		sizes[quality] = len(data)
+	}
+
+	if sizes[95] <= sizes[20] {
+		t.Errorf("quality 95 gave %d bytes, not more than the %d bytes of quality 20", sizes[95], sizes[20])
+	}
+}
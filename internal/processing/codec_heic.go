@@ -0,0 +1,108 @@
+//go:build cgo && libheif
+
+package processing
+
+/*
+#cgo LDFLAGS: -lheif
+#include <libheif/heif.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"unsafe"
+)
+
+// The brands of the HEIF files with HEVC coded images, as found in their ftyp box
+var heicBrands = []string{"heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1"}
+
+func init() {
+	for _, brand := range heicBrands {
+		image.RegisterFormat("heic", "????ftyp"+brand, decodeHEIC, decodeHEICConfig)
+	}
+}
+
+// heifError converts a libheif error, it returns nil on success
+func heifError(err C.struct_heif_error) error {
+	if err.code == C.heif_error_Ok {
+		return nil
+	}
+	return errors.New("heic: " + C.GoString(err.message))
+}
+
+// readHEIC reads a HEIF file into a new libheif context and returns the handle of its primary image. Both must
+// be released by the caller.
+func readHEIC(r io.Reader) (*C.struct_heif_context, *C.struct_heif_image_handle, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil, errors.New("heic: empty file")
+	}
+
+	ctx := C.heif_context_alloc()
+	// libheif copies the data, C can't keep pointers to Go memory
+	if err = heifError(C.heif_context_read_from_memory(ctx, unsafe.Pointer(&data[0]), C.size_t(len(data)), nil)); err != nil {
+		C.heif_context_free(ctx)
+		return nil, nil, err
+	}
+
+	var handle *C.struct_heif_image_handle
+	if err = heifError(C.heif_context_get_primary_image_handle(ctx, &handle)); err != nil {
+		C.heif_context_free(ctx)
+		return nil, nil, err
+	}
+
+	return ctx, handle, nil
+}
+
+func decodeHEICConfig(r io.Reader) (image.Config, error) {
+	ctx, handle, err := readHEIC(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer C.heif_context_free(ctx)
+	defer C.heif_image_handle_release(handle)
+
+	return image.Config{
+		ColorModel: color.NRGBAModel,
+		Width:      int(C.heif_image_handle_get_width(handle)),
+		Height:     int(C.heif_image_handle_get_height(handle)),
+	}, nil
+}
+
+// decodeHEIC decodes the primary image of a HEIF file. libheif applies the rotation and mirroring of the file.
+func decodeHEIC(r io.Reader) (image.Image, error) {
+	ctx, handle, err := readHEIC(r)
+	if err != nil {
+		return nil, err
+	}
+	defer C.heif_context_free(ctx)
+	defer C.heif_image_handle_release(handle)
+
+	var decoded *C.struct_heif_image
+	if err = heifError(C.heif_decode_image(handle, &decoded, C.heif_colorspace_RGB, C.heif_chroma_interleaved_RGBA, nil)); err != nil {
+		return nil, err
+	}
+	defer C.heif_image_release(decoded)
+
+	width := int(C.heif_image_get_width(decoded, C.heif_channel_interleaved))
+	height := int(C.heif_image_get_height(decoded, C.heif_channel_interleaved))
+	var stride C.int
+	plane := C.heif_image_get_plane_readonly(decoded, C.heif_channel_interleaved, &stride)
+	if plane == nil || width <= 0 || height <= 0 {
+		return nil, errors.New("heic: decoded image has no pixels")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	pixels := unsafe.Slice((*byte)(unsafe.Pointer(plane)), int(stride)*height)
+	for y := 0; y < height; y++ {
+		copy(img.Pix[y*img.Stride:y*img.Stride+width*4], pixels[y*int(stride):])
+	}
+
+	return img, nil
+}
@@ -0,0 +1,40 @@
+//go:build cgo && libheif
+
+package processing
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"testing"
+)
+
+// testdata/sample.heic is a 64x48 quadrantImage encoded by libheif with x265
+func TestHEICDecode(t *testing.T) {
+	data, err := os.ReadFile("testdata/sample.heic")
+	if err != nil {
+		t.Fatalf("failed to read the fixture: %v", err)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("image.DecodeConfig() = %v", err)
+	}
+	if format != "heic" || cfg.Width != 64 || cfg.Height != 48 {
+		t.Fatalf("image.DecodeConfig() = %s %dx%d, want heic 64x48", format, cfg.Width, cfg.Height)
+	}
+
+	codec, err := DetectCodec(bytes.NewReader(data))
+	if err != nil || codec.Name != "heic" {
+		t.Fatalf("DetectCodec() = %v, %v, want heic", codec, err)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("image.Decode() = %v", err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(64, 48) {
+		t.Fatalf("decoded image of %v, want 64x48", size)
+	}
+	assertQuadrants(t, img, 16)
+}
@@ -0,0 +1,132 @@
+//go:build cgo && libjpeg
+
+package processing
+
+/*
+#cgo LDFLAGS: -ljpeg
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <setjmp.h>
+#include <jpeglib.h>
+
+struct error_manager {
+	struct jpeg_error_mgr pub;
+	jmp_buf jump;
+	char message[JMSG_LENGTH_MAX];
+};
+
+static void error_exit(j_common_ptr cinfo) {
+	struct error_manager* err = (struct error_manager*)cinfo->err;
+	(*cinfo->err->format_message)(cinfo, err->message);
+	longjmp(err->jump, 1);
+}
+
+// encode_rgb compresses 8-bit RGB rows into a buffer allocated by libjpeg. h_samp and v_samp are the sampling
+// factors of the luma relative to the chroma, e.g. 2 and 2 for 4:2:0.
+static int encode_rgb(unsigned char* pixels, int width, int height, int stride, int quality, int progressive,
+		int h_samp, int v_samp, unsigned char** out, unsigned long* out_size, char* message) {
+	struct jpeg_compress_struct cinfo;
+	struct error_manager err;
+
+	cinfo.err = jpeg_std_error(&err.pub);
+	err.pub.error_exit = error_exit;
+	if (setjmp(err.jump)) {
+		jpeg_destroy_compress(&cinfo);
+		if (*out != NULL) {
+			free(*out);
+			*out = NULL;
+		}
+		strncpy(message, err.message, JMSG_LENGTH_MAX);
+		return 0;
+	}
+
+	jpeg_create_compress(&cinfo);
+	jpeg_mem_dest(&cinfo, out, out_size);
+
+	cinfo.image_width = width;
+	cinfo.image_height = height;
+	cinfo.input_components = 3;
+	cinfo.in_color_space = JCS_RGB;
+	jpeg_set_defaults(&cinfo);
+	jpeg_set_quality(&cinfo, quality, TRUE);
+	cinfo.comp_info[0].h_samp_factor = h_samp;
+	cinfo.comp_info[0].v_samp_factor = v_samp;
+	if (progressive) {
+		jpeg_simple_progression(&cinfo);
+	}
+
+	jpeg_start_compress(&cinfo, TRUE);
+	while (cinfo.next_scanline < cinfo.image_height) {
+		JSAMPROW row = pixels + (size_t)cinfo.next_scanline * stride;
+		jpeg_write_scanlines(&cinfo, &row, 1);
+	}
+	jpeg_finish_compress(&cinfo);
+	jpeg_destroy_compress(&cinfo);
+
+	return 1;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"io"
+	"unsafe"
+
+	"github.com/disintegration/imaging"
+)
+
+func init() {
+	// libjpeg supports every JPEG option
+	setEncoder("jpeg", encodeLibJPEG, nil)
+}
+
+// Luma sampling factors of the chroma subsampling modes
+var jpegSamplingFactors = map[string][2]C.int{
+	"":    {2, 2},
+	"420": {2, 2},
+	"422": {2, 1},
+	"444": {1, 1},
+}
+
+// encodeLibJPEG encodes with libjpeg, which unlike image/jpeg can write progressive JPEGs and choose the chroma
+// subsampling. Transparent pixels are composed over black, like image/jpeg does.
+func encodeLibJPEG(w io.Writer, img image.Image, opts EncodeOptions) error {
+	nrgba := imaging.Clone(img)
+	bounds := nrgba.Bounds()
+	if bounds.Empty() {
+		return errors.New("jpeg: empty image")
+	}
+
+	width, height := bounds.Dx(), bounds.Dy()
+	rgb := make([]byte, width*height*3)
+	for y := 0; y < height; y++ {
+		row := nrgba.Pix[y*nrgba.Stride:]
+		for x := 0; x < width; x++ {
+			r, g, b, a := uint32(row[x*4]), uint32(row[x*4+1]), uint32(row[x*4+2]), uint32(row[x*4+3])
+			i := (y*width + x) * 3
+			rgb[i], rgb[i+1], rgb[i+2] = byte(r*a/255), byte(g*a/255), byte(b*a/255)
+		}
+	}
+
+	progressive := C.int(0)
+	if opts.Progressive {
+		progressive = 1
+	}
+	sampling := jpegSamplingFactors[opts.Subsampling]
+
+	var output *C.uchar
+	var size C.ulong
+	var message [C.JMSG_LENGTH_MAX]C.char
+	ok := C.encode_rgb((*C.uchar)(unsafe.Pointer(&rgb[0])), C.int(width), C.int(height), C.int(width*3),
+		C.int(opts.Quality), progressive, sampling[0], sampling[1], &output, &size, &message[0])
+	if ok == 0 {
+		return errors.New("jpeg: " + C.GoString(&message[0]))
+	}
+	defer C.free(unsafe.Pointer(output))
+
+	_, err := w.Write(C.GoBytes(unsafe.Pointer(output), C.int(size)))
+	return err
+}
@@ -0,0 +1,57 @@
+//go:build cgo && libjpeg
+
+package processing
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func TestLibJPEGRoundTrip(t *testing.T) {
+	codec, _ := LookupCodec("jpeg")
+	src := quadrantImage(64, 48)
+
+	tests := []struct {
+		name        string
+		opts        EncodeOptions
+		progressive bool
+		subsampling image.YCbCrSubsampleRatio
+	}{
+		{"baseline 420", EncodeOptions{Quality: 90, Subsampling: "420"}, false, image.YCbCrSubsampleRatio420},
+		{"progressive", EncodeOptions{Quality: 90, Progressive: true}, true, image.YCbCrSubsampleRatio420},
+		{"422", EncodeOptions{Quality: 90, Subsampling: "422"}, false, image.YCbCrSubsampleRatio422},
+		{"444", EncodeOptions{Quality: 90, Subsampling: "444"}, false, image.YCbCrSubsampleRatio444},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := codec.Check(tt.opts); err != nil {
+				t.Fatalf("Check() = %v", err)
+			}
+
+			var buf bytes.Buffer
+			if err := codec.Encode(&buf, src, tt.opts); err != nil {
+				t.Fatalf("Encode() = %v", err)
+			}
+
+			// SOF2 starts the frame of a progressive JPEG
+			if progressive := bytes.Contains(buf.Bytes(), []byte{0xff, 0xc2}); progressive != tt.progressive {
+				t.Errorf("progressive = %v, want %v", progressive, tt.progressive)
+			}
+
+			img, err := jpeg.Decode(&buf)
+			if err != nil {
+				t.Fatalf("jpeg.Decode() = %v", err)
+			}
+			ycbcr, ok := img.(*image.YCbCr)
+			if !ok {
+				t.Fatalf("decoded a %T, want an *image.YCbCr", img)
+			}
+			if ycbcr.SubsampleRatio != tt.subsampling {
+				t.Errorf("subsampling = %v, want %v", ycbcr.SubsampleRatio, tt.subsampling)
+			}
+			assertQuadrants(t, img, 16)
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package processing
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+)
+
+// quadrantImage is an opaque image with a different color in every quadrant, so the tests can check that an encoded
+// image decodes to the same picture
+func quadrantImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, quadrantColor(x < width/2, y < height/2))
+		}
+	}
+	return img
+}
+
+func quadrantColor(left bool, top bool) color.NRGBA {
+	c := color.NRGBA{R: 30, G: 40, B: 90, A: 255}
+	if left {
+		c.R = 220
+	}
+	if top {
+		c.G = 200
+	}
+	return c
+}
+
+// assertQuadrants checks the center of every quadrant of a decoded quadrantImage, within a tolerance for the lossy
+// formats
+func assertQuadrants(t *testing.T, img image.Image, tolerance int) {
+	t.Helper()
+
+	bounds := img.Bounds()
+	for _, left := range []bool{true, false} {
+		for _, top := range []bool{true, false} {
+			x, y := bounds.Min.X+bounds.Dx()/4, bounds.Min.Y+bounds.Dy()/4
+			if !left {
+				x += bounds.Dx() / 2
+			}
+			if !top {
+				y += bounds.Dy() / 2
+			}
+
+			got := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			want := quadrantColor(left, top)
+			if diff(got.R, want.R) > tolerance || diff(got.G, want.G) > tolerance || diff(got.B, want.B) > tolerance || got.A != want.A {
+				t.Errorf("pixel at %d,%d = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func diff(a uint8, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+func TestLookupCodec(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"jpeg", "jpeg"},
+		{".JPG", "jpeg"},
+		{"tif", "tiff"},
+		{"heif", "heic"},
+		{".webp", "webp"},
+	}
+	for _, tt := range tests {
+		codec, ok := LookupCodec(tt.name)
+		if !ok || codec.Name != tt.want {
+			t.Errorf("LookupCodec(%q) = %v, %v, want %s", tt.name, codec, ok, tt.want)
+		}
+	}
+
+	if _, ok := LookupCodec("psd"); ok {
+		t.Error("LookupCodec(psd) found a codec")
+	}
+}
+
+func TestDetectCodec(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, quadrantImage(8, 8)); err != nil {
+		t.Fatalf("png.Encode() = %v", err)
+	}
+
+	codec, err := DetectCodec(&buf)
+	if err != nil || codec.Name != "png" {
+		t.Fatalf("DetectCodec(png) = %v, %v, want png", codec, err)
+	}
+
+	if _, err = DetectCodec(strings.NewReader("not an image")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("DetectCodec(text) = %v, want ErrUnsupportedFormat", err)
+	}
+}
+
+func TestEncodePNG(t *testing.T) {
+	src := gradientImage(64, 64)
+
+	tests := []struct {
+		name string
+		opts EncodeOptions
+		// paletted is the size of the palette of the output, 0 for a truecolor PNG
+		paletted int
+	}{
+		{"default", EncodeOptions{}, 0},
+		{"no compression", EncodeOptions{Compression: "none"}, 0},
+		{"fast", EncodeOptions{Compression: "fast"}, 0},
+		{"best", EncodeOptions{Compression: "best"}, 0},
+		{"colors", EncodeOptions{Colors: 16}, 16},
+		{"colors with dithering", EncodeOptions{Colors: 16, Dither: true}, 16},
+	}
+
+	sizes := map[string]int{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := encodePNG(&buf, src, tt.opts); err != nil {
+				t.Fatalf("encodePNG() = %v", err)
+			}
+			sizes[tt.name] = buf.Len()
+
+			img, err := png.Decode(&buf)
+			if err != nil {
+				t.Fatalf("png.Decode() = %v", err)
+			}
+			if img.Bounds() != src.Bounds() {
+				t.Fatalf("bounds = %v, want %v", img.Bounds(), src.Bounds())
+			}
+
+			paletted, ok := img.(*image.Paletted)
+			if tt.paletted == 0 {
+				if ok {
+					t.Fatal("decoded a paletted image, want truecolor")
+				}
+				if got := meanChannelError(src, img); got != 0 {
+					t.Errorf("mean channel error = %d, want a lossless image", got)
+				}
+				return
+			}
+			if !ok || len(paletted.Palette) > tt.paletted {
+				t.Errorf("decoded %T, want a palette of up to %d colors", img, tt.paletted)
+			}
+		})
+	}
+
+	if sizes["no compression"] <= sizes["best"] {
+		t.Errorf("uncompressed PNG of %d bytes, want more than the %d bytes of the best compression", sizes["no compression"], sizes["best"])
+	}
+}
+
+func TestEncodeGIF(t *testing.T) {
+	tests := []struct {
+		name string
+		img  image.Image
+		opts EncodeOptions
+		want int
+		// exact is set when the palette has every color of the image
+		exact bool
+	}{
+		{"default palette", gradientImage(64, 64), EncodeOptions{}, 256, false},
+		{"colors", gradientImage(64, 64), EncodeOptions{Colors: 16}, 16, false},
+		{"colors with dithering", gradientImage(64, 64), EncodeOptions{Colors: 16, Dither: true}, 16, false},
+		{"exact palette", quadrantImage(16, 16), EncodeOptions{Colors: 4}, 4, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := encodeGIF(&buf, tt.img, tt.opts); err != nil {
+				t.Fatalf("encodeGIF() = %v", err)
+			}
+
+			img, err := gif.Decode(&buf)
+			if err != nil {
+				t.Fatalf("gif.Decode() = %v", err)
+			}
+			paletted := img.(*image.Paletted)
+			if len(paletted.Palette) > tt.want {
+				t.Errorf("palette of %d colors, want up to %d", len(paletted.Palette), tt.want)
+			}
+			if tt.exact {
+				assertQuadrants(t, img, 0)
+			}
+		})
+	}
+}
+
+func TestCheckJPEG(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    EncodeOptions
+		wantErr bool
+	}{
+		{"defaults", EncodeOptions{Quality: 85}, false},
+		{"420", EncodeOptions{Subsampling: "420"}, false},
+		{"progressive", EncodeOptions{Progressive: true}, true},
+		{"422", EncodeOptions{Subsampling: "422"}, true},
+		{"444", EncodeOptions{Subsampling: "444"}, true},
+		{"options of other formats", EncodeOptions{Lossless: true, Colors: 16, Compression: "best"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkJPEG(tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("checkJPEG() = %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPipelineChecksEncodeOptions(t *testing.T) {
+	jpeg, _ := LookupCodec("jpeg")
+	webp, _ := LookupCodec("webp")
+
+	tests := []struct {
+		name    string
+		ops     model.Operations
+		wantErr bool
+	}{
+		{"png compression", model.Operations{{Type: model.OpFormat, Format: "png"}, {Type: model.OpEncode, Compression: "best", Colors: 64}}, false},
+		{"gif colors", model.Operations{{Type: model.OpFormat, Format: "gif"}, {Type: model.OpEncode, Colors: 16, Dither: true}}, false},
+		{"progressive jpeg", model.Operations{{Type: model.OpEncode, Progressive: true}}, jpeg.Check(EncodeOptions{Progressive: true}) != nil},
+		{"options before the format", model.Operations{{Type: model.OpEncode, Subsampling: "444"}, {Type: model.OpFormat, Format: "png"}}, false},
+		{"lossless webp", model.Operations{{Type: model.OpFormat, Format: "webp"}, {Type: model.OpEncode, Lossless: true}}, !webp.CanEncode()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPipeline(tt.ops); (err != nil) != tt.wantErr {
+				t.Errorf("NewPipeline() = %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+//go:build cgo && libwebp
+
+package processing
+
+/*
+#cgo LDFLAGS: -lwebp
+#include <stdlib.h>
+#include <webp/encode.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"io"
+	"unsafe"
+
+	"github.com/disintegration/imaging"
+)
+
+func init() {
+	setEncoder("webp", encodeWebP, nil)
+}
+
+// encodeWebP encodes a lossy or lossless WebP with libwebp, the decoder is the pure Go one of golang.org/x/image/webp
+func encodeWebP(w io.Writer, img image.Image, opts EncodeOptions) error {
+	nrgba := imaging.Clone(img)
+	bounds := nrgba.Bounds()
+	if bounds.Empty() {
+		return errors.New("webp: empty image")
+	}
+
+	pixels := (*C.uint8_t)(unsafe.Pointer(&nrgba.Pix[0]))
+	var output *C.uint8_t
+	var size C.size_t
+	if opts.Lossless {
+		size = C.WebPEncodeLosslessRGBA(pixels, C.int(bounds.Dx()), C.int(bounds.Dy()), C.int(nrgba.Stride), &output)
+	} else {
+		size = C.WebPEncodeRGBA(pixels, C.int(bounds.Dx()), C.int(bounds.Dy()), C.int(nrgba.Stride), C.float(opts.Quality), &output)
+	}
+	if size == 0 {
+		return errors.New("webp: encoding failed")
+	}
+	defer C.WebPFree(unsafe.Pointer(output))
+
+	_, err := w.Write(C.GoBytes(unsafe.Pointer(output), C.int(size)))
+	return err
+}
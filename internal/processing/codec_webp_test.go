@@ -0,0 +1,40 @@
+//go:build cgo && libwebp
+
+package processing
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestWebPRoundTrip(t *testing.T) {
+	codec, _ := LookupCodec("webp")
+	src := quadrantImage(64, 48)
+
+	tests := []struct {
+		name      string
+		opts      EncodeOptions
+		tolerance int
+	}{
+		{"lossy", EncodeOptions{Quality: 90}, 16},
+		{"lossless", EncodeOptions{Lossless: true}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := codec.Encode(&buf, src, tt.opts); err != nil {
+				t.Fatalf("Encode() = %v", err)
+			}
+
+			img, format, err := image.Decode(&buf)
+			if err != nil {
+				t.Fatalf("image.Decode() = %v", err)
+			}
+			if format != "webp" || img.Bounds().Size() != src.Bounds().Size() {
+				t.Fatalf("decoded a %s image of %v, want a webp image of %v", format, img.Bounds().Size(), src.Bounds().Size())
+			}
+			assertQuadrants(t, img, tt.tolerance)
+		})
+	}
+}
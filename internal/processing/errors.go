@@ -0,0 +1,34 @@
+package processing
+
+import "errors"
+
+// ErrTaskCancelled is the cause of the context of a task that was cancelled while it was being processed.
+var ErrTaskCancelled = errors.New("task cancelled")
+
+// permanentError marks a failure that retrying won't fix, like a corrupt image or an invalid operation.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps err so the task fails right away instead of being retried.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err or any error it wraps was marked with Permanent.
+// Errors that aren't marked, e.g. storage and network errors, are considered transient.
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
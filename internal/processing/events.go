@@ -0,0 +1,93 @@
+package processing
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+)
+
+type EventType string
+
+const (
+	// EventStatus is published when a task changes status
+	EventStatus EventType = "status"
+	// EventRendition is published when a rendition of a task is stored
+	EventRendition EventType = "rendition"
+)
+
+// Event is a progress update of a task being processed by this instance.
+type Event struct {
+	Type      EventType        `json:"type"`
+	TaskID    int64            `json:"task_id"`
+	Status    model.TaskStatus `json:"status"`
+	Attempt   int              `json:"attempt,omitempty"`
+	Rendition string           `json:"rendition,omitempty"`
+	// Completed and Total count the renditions of the task
+	Completed int       `json:"completed,omitempty"`
+	Total     int       `json:"total,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// subscriberBuffer is the number of events a subscriber can fall behind before it starts missing them
+const subscriberBuffer = 16
+
+// broker fans the events of the tasks out to their subscribers. Publishing never blocks the workers, so a
+// subscriber that doesn't keep up misses events and should resynchronize from the database.
+type broker struct {
+	mu          sync.Mutex
+	subscribers map[int64]map[chan Event]struct{}
+}
+
+func newBroker() *broker {
+	return &broker{subscribers: make(map[int64]map[chan Event]struct{})}
+}
+
+func (b *broker) subscribe(taskID int64) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subscribers[taskID] == nil {
+		b.subscribers[taskID] = make(map[chan Event]struct{})
+	}
+	b.subscribers[taskID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			delete(b.subscribers[taskID], ch)
+			if len(b.subscribers[taskID]) == 0 {
+				delete(b.subscribers, taskID)
+			}
+		})
+	}
+}
+
+func (b *broker) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[event.TaskID] {
+		select {
+		case ch <- event:
+		default:
+			// The subscriber is too slow, drop the event
+		}
+	}
+}
+
+// Subscribe returns the events of a task published by this instance until the returned function is called.
+// Tasks processed by other instances, or events missed by a slow subscriber, have to be read from the database.
+func (s *Service) Subscribe(taskID int64) (<-chan Event, func()) {
+	return s.events.subscribe(taskID)
+}
+
+func (s *Service) publish(event Event) {
+	event.Time = time.Now().UTC()
+	s.events.publish(event)
+}
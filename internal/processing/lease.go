@@ -0,0 +1,83 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
+)
+
+// heartbeat renews the lease of a task until stop is called. If the lease is lost, e.g. because the reaper
+// gave the task to another instance, onLost is called so the task can be aborted.
+func (s *Service) heartbeat(taskID int64, attempt int, onLost func()) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(s.config.LeaseDuration / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err := s.repo.ExtendLease(ctx, taskID, s.config.InstanceID, attempt, s.config.LeaseDuration)
+			cancel()
+
+			if errors.Is(err, repository.ErrLeaseLost) {
+				log.Printf("Lost the lease of task %d, aborting it", taskID)
+				onLost()
+				return
+			}
+			if err != nil {
+				// The lease is still valid until it expires, try again on the next tick
+				log.Printf("Failed to extend the lease of task %d: %v", taskID, err)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// reaper returns the tasks whose lease has expired to pending, so the tasks of a crashed instance are picked
+// up again by the pollers.
+func (s *Service) reaper() {
+	defer s.loopWG.Done()
+
+	ticker := time.NewTicker(s.config.ReaperInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.loopCtx.Done():
+			log.Println("Reaper exiting.")
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(s.loopCtx, 10*time.Second)
+		released, err := s.repo.ReleaseExpiredLeases(ctx, s.config.MaxAttempts)
+		cancel()
+
+		if err != nil {
+			log.Printf("Reaper failed to release expired leases: %v", err)
+			continue
+		}
+
+		if released > 0 {
+			log.Printf("Reaper released %d tasks with expired leases", released)
+			s.Wake()
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package processing
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+)
+
+const defaultJPEGQuality = 95
+
+// DefaultOperations is used for tasks that were submitted without an operations spec.
+var DefaultOperations = model.Operations{
+	{Type: model.OpResize, Width: 800},
+	{Type: model.OpFormat, Format: "jpeg"},
+}
+
+type step func(img image.Image) image.Image
+
+// Pipeline is a compiled, ordered list of operations plus the output encoding settings.
+type Pipeline struct {
+	steps []step
+	// ops are the compiled operations, kept to compute the output size before anything is decoded
+	ops     model.Operations
+	codec   *Codec
+	options EncodeOptions
+}
+
+// NewPipeline compiles the operations into a pipeline. It returns an error if an operation is missing
+// the parameters it needs, so it can be used to validate a spec before the task is created.
+func NewPipeline(ops model.Operations) (*Pipeline, error) {
+	jpeg, _ := LookupCodec("jpeg")
+	p := &Pipeline{codec: jpeg, options: EncodeOptions{Quality: defaultJPEGQuality}}
+	return p.compileAll(ops)
+}
+
+// Extend compiles ops into a new pipeline that inherits the output settings of p but none of its steps,
+// so it is meant to run on the output of p.
+func (p *Pipeline) Extend(ops model.Operations) (*Pipeline, error) {
+	next := &Pipeline{codec: p.codec, options: p.options}
+	return next.compileAll(ops)
+}
+
+func (p *Pipeline) compileAll(ops model.Operations) (*Pipeline, error) {
+	for i, op := range ops {
+		s, err := p.compile(op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Type, err)
+		}
+		if s != nil {
+			p.steps = append(p.steps, s)
+			p.ops = append(p.ops, op)
+		}
+	}
+
+	// The format and the options can be set in any order, so they're checked together
+	if err := p.codec.Check(p.options); err != nil {
+		return nil, fmt.Errorf("%s output: %w", p.codec.Name, err)
+	}
+
+	return p, nil
+}
+
+func (p *Pipeline) compile(op model.Operation) (step, error) {
+	switch op.Type {
+	case model.OpResize:
+		if op.Width == 0 && op.Height == 0 {
+			return nil, fmt.Errorf("width or height is required")
+		}
+		switch op.Fit {
+		case "":
+			return func(img image.Image) image.Image {
+				return imaging.Resize(img, op.Width, op.Height, imaging.Lanczos)
+			}, nil
+		case "fit":
+			if op.Width == 0 || op.Height == 0 {
+				return nil, fmt.Errorf("both width and height are required when fit is %q", op.Fit)
+			}
+			return func(img image.Image) image.Image {
+				return imaging.Fit(img, op.Width, op.Height, imaging.Lanczos)
+			}, nil
+		case "fill":
+			if op.Width == 0 || op.Height == 0 {
+				return nil, fmt.Errorf("both width and height are required when fit is %q", op.Fit)
+			}
+			anchor := parseAnchor(op.Anchor)
+			return func(img image.Image) image.Image {
+				return imaging.Fill(img, op.Width, op.Height, anchor, imaging.Lanczos)
+			}, nil
+		default:
+			return nil, fmt.Errorf("unknown fit %q", op.Fit)
+		}
+
+	case model.OpCrop:
+		if op.Width <= 0 || op.Height <= 0 {
+			return nil, fmt.Errorf("width and height are required")
+		}
+		if op.Anchor != "" {
+			anchor := parseAnchor(op.Anchor)
+			return func(img image.Image) image.Image {
+				return imaging.CropAnchor(img, op.Width, op.Height, anchor)
+			}, nil
+		}
+		return func(img image.Image) image.Image {
+			origin := img.Bounds().Min
+			return imaging.Crop(img, image.Rect(op.X, op.Y, op.X+op.Width, op.Y+op.Height).Add(origin))
+		}, nil
+
+	case model.OpRotate:
+		// imaging rotates counter-clockwise, the right angles have lossless fast paths
+		switch normalizeAngle(op.Angle) {
+		case 0:
+			return nil, nil
+		case 90:
+			return func(img image.Image) image.Image { return imaging.Rotate90(img) }, nil
+		case 180:
+			return func(img image.Image) image.Image { return imaging.Rotate180(img) }, nil
+		case 270:
+			return func(img image.Image) image.Image { return imaging.Rotate270(img) }, nil
+		default:
+			return func(img image.Image) image.Image {
+				return imaging.Rotate(img, op.Angle, color.Transparent)
+			}, nil
+		}
+
+	case model.OpFlip:
+		switch op.Direction {
+		case "horizontal":
+			return func(img image.Image) image.Image { return imaging.FlipH(img) }, nil
+		case "vertical":
+			return func(img image.Image) image.Image { return imaging.FlipV(img) }, nil
+		default:
+			return nil, fmt.Errorf("unknown direction %q", op.Direction)
+		}
+
+	case model.OpBlur:
+		if op.Sigma <= 0 {
+			return nil, fmt.Errorf("sigma must be greater than 0")
+		}
+		return func(img image.Image) image.Image { return imaging.Blur(img, op.Sigma) }, nil
+
+	case model.OpSharpen:
+		if op.Sigma <= 0 {
+			return nil, fmt.Errorf("sigma must be greater than 0")
+		}
+		return func(img image.Image) image.Image { return imaging.Sharpen(img, op.Sigma) }, nil
+
+	case model.OpGrayscale:
+		return func(img image.Image) image.Image { return imaging.Grayscale(img) }, nil
+
+	case model.OpFormat:
+		codec, ok := LookupCodec(op.Format)
+		if !ok {
+			return nil, fmt.Errorf("unsupported format %q", op.Format)
+		}
+		if !codec.CanEncode() {
+			return nil, fmt.Errorf("format %q can't be encoded by this build", op.Format)
+		}
+		p.codec = codec
+		return nil, nil
+
+	case model.OpQuality:
+		if op.Quality < 1 || op.Quality > 100 {
+			return nil, fmt.Errorf("quality must be between 1 and 100")
+		}
+		p.options.Quality = op.Quality
+		return nil, nil
+
+	case model.OpEncode:
+		if op.Quality != 0 {
+			if op.Quality < 1 || op.Quality > 100 {
+				return nil, fmt.Errorf("quality must be between 1 and 100")
+			}
+			p.options.Quality = op.Quality
+		}
+		p.options.Progressive = op.Progressive
+		p.options.Subsampling = op.Subsampling
+		p.options.Compression = op.Compression
+		p.options.Colors = op.Colors
+		p.options.Dither = op.Dither
+		p.options.Lossless = op.Lossless
+		return nil, nil
+
+	default:
+		return nil, fmt.Errorf("unknown operation")
+	}
+}
+
+// Apply runs the steps in order. The context is checked between steps so a long pipeline can be aborted.
+func (p *Pipeline) Apply(ctx context.Context, img image.Image) (image.Image, error) {
+	for _, s := range p.steps {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		img = s(img)
+	}
+	return img, nil
+}
+
+// Sizes returns the size of every image the steps produce from an image of the given size, in order, so the limits
+// can be checked before the image is decoded. The last one is the size of the output, none if there are no steps.
+func (p *Pipeline) Sizes(size image.Point) []image.Point {
+	var sizes []image.Point
+	for _, op := range p.ops {
+		sizes = append(sizes, operationSizes(op, size)...)
+		size = sizes[len(sizes)-1]
+	}
+	return sizes
+}
+
+// operationSizes returns the size of the output of an operation, preceded by the size of the temporary image it
+// goes through if it has one. It follows the rules of the imaging functions the steps call.
+func operationSizes(op model.Operation, src image.Point) []image.Point {
+	switch op.Type {
+	case model.OpResize:
+		switch op.Fit {
+		case "fit":
+			if src.X <= op.Width && src.Y <= op.Height {
+				return []image.Point{src}
+			}
+			aspectRatio := float64(src.X) / float64(src.Y)
+			if aspectRatio > float64(op.Width)/float64(op.Height) {
+				return []image.Point{resizedSize(src, op.Width, int(float64(op.Width)/aspectRatio))}
+			}
+			return []image.Point{resizedSize(src, int(float64(op.Height)*aspectRatio), op.Height)}
+		case "fill":
+			dst := image.Pt(op.Width, op.Height)
+			if src == dst || (src.X >= 100 && src.Y >= 100) {
+				// Cropped to the aspect ratio first, so the temporary image is never larger than the source
+				return []image.Point{dst}
+			}
+			// Small images are scaled to cover the size before they're cropped
+			if float64(src.X)/float64(src.Y) < float64(op.Width)/float64(op.Height) {
+				return []image.Point{resizedSize(src, op.Width, 0), dst}
+			}
+			return []image.Point{resizedSize(src, 0, op.Height), dst}
+		default:
+			return []image.Point{resizedSize(src, op.Width, op.Height)}
+		}
+
+	case model.OpCrop:
+		if op.Anchor != "" {
+			return []image.Point{image.Pt(min(op.Width, src.X), min(op.Height, src.Y))}
+		}
+		crop := image.Rect(op.X, op.Y, op.X+op.Width, op.Y+op.Height).Intersect(image.Rectangle{Max: src})
+		return []image.Point{crop.Size()}
+
+	case model.OpRotate:
+		switch angle := normalizeAngle(op.Angle); angle {
+		case 90, 270:
+			return []image.Point{image.Pt(src.Y, src.X)}
+		case 180:
+			return []image.Point{src}
+		default:
+			// The bounding box of the rotated image
+			sin, cos := math.Sincos(angle * math.Pi / 180)
+			width := math.Abs(float64(src.X)*cos) + math.Abs(float64(src.Y)*sin)
+			height := math.Abs(float64(src.X)*sin) + math.Abs(float64(src.Y)*cos)
+			return []image.Point{image.Pt(int(math.Ceil(width)), int(math.Ceil(height)))}
+		}
+
+	default:
+		return []image.Point{src}
+	}
+}
+
+// resizedSize is the size imaging.Resize produces, a side of 0 keeps the aspect ratio
+func resizedSize(src image.Point, width int, height int) image.Point {
+	if width == 0 {
+		width = int(math.Max(1, math.Floor(float64(height)*float64(src.X)/float64(src.Y)+0.5)))
+	}
+	if height == 0 {
+		height = int(math.Max(1, math.Floor(float64(width)*float64(src.Y)/float64(src.X)+0.5)))
+	}
+	return image.Pt(width, height)
+}
+
+// Encode writes the image in the output format of the pipeline.
+func (p *Pipeline) Encode(w io.Writer, img image.Image) error {
+	return p.codec.Encode(w, img, p.options)
+}
+
+// withQuality returns a copy of the pipeline that encodes with another quality.
+func (p *Pipeline) withQuality(quality int) *Pipeline {
+	next := *p
+	next.options.Quality = quality
+	return &next
+}
+
+// Quality returns the quality the output is encoded with, or 0 if the output format is lossless.
+func (p *Pipeline) Quality() int {
+	if !p.codec.lossy(p.options) {
+		return 0
+	}
+	return p.options.Quality
+}
+
+// Extension returns the file extension (without the dot) of the output format.
+func (p *Pipeline) Extension() string {
+	return p.codec.Extension()
+}
+
+// ContentType returns the content type of the output format.
+func (p *Pipeline) ContentType() string {
+	return p.codec.ContentType
+}
+
+func parseAnchor(anchor string) imaging.Anchor {
+	switch anchor {
+	case "top":
+		return imaging.Top
+	case "bottom":
+		return imaging.Bottom
+	case "left":
+		return imaging.Left
+	case "right":
+		return imaging.Right
+	case "top_left":
+		return imaging.TopLeft
+	case "top_right":
+		return imaging.TopRight
+	case "bottom_left":
+		return imaging.BottomLeft
+	case "bottom_right":
+		return imaging.BottomRight
+	default:
+		return imaging.Center
+	}
+}
+
+func normalizeAngle(angle float64) float64 {
+	for angle < 0 {
+		angle += 360
+	}
+	for angle >= 360 {
+		angle -= 360
+	}
+	return angle
+}
@@ -0,0 +1,71 @@
+package processing
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+)
+
+func TestPipelineSizes(t *testing.T) {
+	tests := []struct {
+		name string
+		src  image.Point
+		ops  model.Operations
+	}{
+		{"resize width only", image.Pt(40, 30), model.Operations{{Type: model.OpResize, Width: 100}}},
+		{"resize height only", image.Pt(3, 70), model.Operations{{Type: model.OpResize, Height: 9}}},
+		{"resize both", image.Pt(40, 30), model.Operations{{Type: model.OpResize, Width: 7, Height: 90}}},
+		{"fit larger", image.Pt(300, 100), model.Operations{{Type: model.OpResize, Width: 50, Height: 50, Fit: "fit"}}},
+		{"fit smaller", image.Pt(30, 10), model.Operations{{Type: model.OpResize, Width: 50, Height: 50, Fit: "fit"}}},
+		{"fill small", image.Pt(2, 60), model.Operations{{Type: model.OpResize, Width: 40, Height: 3, Fit: "fill"}}},
+		{"fill large", image.Pt(150, 120), model.Operations{{Type: model.OpResize, Width: 40, Height: 90, Fit: "fill"}}},
+		{"crop", image.Pt(40, 30), model.Operations{{Type: model.OpCrop, X: 30, Y: 5, Width: 20, Height: 10}}},
+		{"crop anchor", image.Pt(40, 30), model.Operations{{Type: model.OpCrop, Width: 60, Height: 10, Anchor: "center"}}},
+		{"rotate 90", image.Pt(40, 30), model.Operations{{Type: model.OpRotate, Angle: 90}}},
+		{"rotate 30", image.Pt(40, 30), model.Operations{{Type: model.OpRotate, Angle: 30}}},
+		{"chain", image.Pt(40, 30), model.Operations{
+			{Type: model.OpResize, Width: 80},
+			{Type: model.OpRotate, Angle: 270},
+			{Type: model.OpBlur, Sigma: 1},
+			{Type: model.OpCrop, Width: 50, Height: 50, Anchor: "top"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := NewPipeline(tt.ops)
+			if err != nil {
+				t.Fatalf("NewPipeline() = %v", err)
+			}
+
+			sizes := pipeline.Sizes(tt.src)
+			if len(sizes) == 0 {
+				t.Fatal("Sizes() returned no sizes")
+			}
+
+			img, err := pipeline.Apply(context.Background(), image.NewNRGBA(image.Rectangle{Max: tt.src}))
+			if err != nil {
+				t.Fatalf("Apply() = %v", err)
+			}
+			if got, want := sizes[len(sizes)-1], img.Bounds().Size(); got != want {
+				t.Errorf("Sizes() ends with %v, Apply() produced %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPipelineSizesOfFillCoverImage(t *testing.T) {
+	pipeline, err := NewPipeline(model.Operations{{Type: model.OpResize, Width: 16384, Height: 1, Fit: "fill"}})
+	if err != nil {
+		t.Fatalf("NewPipeline() = %v", err)
+	}
+
+	// A small image is scaled to cover the size before the crop
+	sizes := pipeline.Sizes(image.Pt(1, 16384))
+	want := []image.Point{image.Pt(16384, 268435456), image.Pt(16384, 1)}
+	if len(sizes) != len(want) || sizes[0] != want[0] || sizes[1] != want[1] {
+		t.Errorf("Sizes() = %v, want %v", sizes, want)
+	}
+}
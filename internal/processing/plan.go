@@ -0,0 +1,79 @@
+package processing
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+)
+
+// Plan is the compiled form of a task. The base pipeline runs once on the decoded original and every
+// rendition pipeline runs on its output.
+type Plan struct {
+	Base       *Pipeline
+	Renditions []RenditionPipeline
+}
+
+type RenditionPipeline struct {
+	Name string
+	// MaxBytes is the size limit of the output, 0 if it has none
+	MaxBytes int64
+	// MinSSIM makes the quality the lowest one that keeps this SSIM, 0 to use the service default
+	MinSSIM float64
+	*Pipeline
+}
+
+// NewPlan compiles the operations and renditions of the task. A task without renditions gets a single
+// default rendition built from its operations, falling back to DefaultOperations.
+func NewPlan(task *model.ImageProcessingTask) (*Plan, error) {
+	baseOps := task.Operations
+	renditions := task.Renditions
+	if len(renditions) == 0 {
+		ops := task.Operations
+		if len(ops) == 0 {
+			ops = DefaultOperations
+		}
+		baseOps = nil
+		renditions = []model.Rendition{{Name: model.DefaultRenditionName, Operations: ops}}
+	}
+
+	base, err := NewPipeline(baseOps)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Base: base}
+	for _, rendition := range renditions {
+		p, err := base.Extend(rendition.Operations)
+		if err != nil {
+			return nil, fmt.Errorf("rendition %q: %w", rendition.Name, err)
+		}
+		plan.Renditions = append(plan.Renditions, RenditionPipeline{Name: rendition.Name, MaxBytes: rendition.MaxBytes, MinSSIM: rendition.MinSSIM, Pipeline: p})
+	}
+
+	return plan, nil
+}
+
+// Sizes returns the size of every image the plan produces from an image of the given size: the ones of the base
+// pipeline, then the ones of every rendition from the base output.
+func (p *Plan) Sizes(size image.Point) []image.Point {
+	sizes := p.Base.Sizes(size)
+	if len(sizes) > 0 {
+		size = sizes[len(sizes)-1]
+	}
+	for _, rendition := range p.Renditions {
+		sizes = append(sizes, rendition.Sizes(size)...)
+	}
+	return sizes
+}
+
+// measuresSSIM reports whether a rendition chooses its quality by SSIM, given the minimum SSIM of the service that
+// applies to the renditions without their own
+func (p *Plan) measuresSSIM(defaultMinSSIM float64) bool {
+	for _, rendition := range p.Renditions {
+		if (rendition.MinSSIM > 0 || defaultMinSSIM > 0) && rendition.Quality() > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package processing
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+)
+
+// poller claims pending tasks from the database and hands them to the workers. Claiming is atomic in the
+// repository, so several instances can poll the same table.
+func (s *Service) poller() {
+	defer s.loopWG.Done()
+
+	ticker := time.NewTicker(s.config.PollingInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep claiming while full batches come back so a backlog is drained without waiting for the ticker
+		for s.poll() {
+		}
+
+		select {
+		case <-s.loopCtx.Done():
+			log.Println("Poller exiting.")
+			return
+		case <-ticker.C:
+		case <-s.wakeChan:
+		}
+	}
+}
+
+// poll claims one batch and reports whether it was full.
+func (s *Service) poll() bool {
+	// Only claim what the idle workers can start right away. Claimed tasks are invisible to the other instances
+	// and their lease isn't renewed until a worker picks them up, so they must not wait in the channel.
+	idle := int(s.workers.Load()) - int(s.busyWorkers.Load()) - len(s.taskChan)
+	limit := min(s.config.TaskBatchSize, cap(s.taskChan)-len(s.taskChan), idle)
+	if limit <= 0 || s.loopCtx.Err() != nil {
+		return false
+	}
+
+	var tasks []model.ImageProcessingTask
+	unused := 0
+	for priority, quota := range s.scheduler.allocate(limit) {
+		claimed := s.claim(priority, quota)
+		tasks = append(tasks, claimed...)
+		unused += quota - len(claimed)
+	}
+
+	// Hand the share of the levels that had no work to the ones that still have some, highest first
+	for _, priority := range model.Priorities {
+		if unused == 0 {
+			break
+		}
+		claimed := s.claim(priority, unused)
+		tasks = append(tasks, claimed...)
+		unused -= len(claimed)
+	}
+
+	// Higher priorities are handed to the workers first
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].Priority > tasks[j].Priority })
+
+	for _, task := range tasks {
+		// The poller is the only sender and the buffer had room for the batch, so this won't block for long
+		s.taskChan <- task
+	}
+
+	if len(tasks) > 0 {
+		log.Printf("Poller claimed %d tasks", len(tasks))
+	}
+
+	return len(tasks) == limit
+}
+
+func (s *Service) claim(priority model.TaskPriority, limit int) []model.ImageProcessingTask {
+	if limit <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(s.loopCtx, 10*time.Second)
+	defer cancel()
+
+	tasks, err := s.repo.ClaimPendingTasks(ctx, s.config.InstanceID, s.config.LeaseDuration, priority, limit)
+	if err != nil {
+		log.Printf("Poller failed to claim pending tasks: %v", err)
+		return nil
+	}
+
+	return tasks
+}
@@ -0,0 +1,126 @@
+package processing
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// maxQuantizeSamples bounds the number of pixels the palette is computed from
+const maxQuantizeSamples = 1 << 16
+
+// medianCut is a draw.Quantizer that builds a palette of up to colors entries by repeatedly splitting the box of
+// colors with the widest channel at its median.
+type medianCut struct {
+	colors int
+}
+
+var _ draw.Quantizer = medianCut{}
+
+type colorBox []color.NRGBA
+
+func (m medianCut) Quantize(p color.Palette, img image.Image) color.Palette {
+	size := m.colors - len(p)
+	if size <= 0 {
+		return p
+	}
+
+	boxes := []colorBox{samplePixels(img)}
+	for len(boxes) < size {
+		// Split the box with the widest channel
+		widest, widestChannel, widestRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			channel, channelRange := box.widestChannel()
+			if channelRange > widestRange {
+				widest, widestChannel, widestRange = i, channel, channelRange
+			}
+		}
+		if widest < 0 {
+			// Every box has a single color
+			break
+		}
+
+		box := boxes[widest]
+		sort.Slice(box, func(i, j int) bool {
+			return channelValue(box[i], widestChannel) < channelValue(box[j], widestChannel)
+		})
+		median := len(box) / 2
+		boxes[widest] = box[:median]
+		boxes = append(boxes, box[median:])
+	}
+
+	for _, box := range boxes {
+		if len(box) > 0 {
+			p = append(p, box.average())
+		}
+	}
+	return p
+}
+
+// samplePixels returns the colors of the image, or of an evenly spread sample of them for large images
+func samplePixels(img image.Image) colorBox {
+	bounds := img.Bounds()
+	step := max(1, bounds.Dx()*bounds.Dy()/maxQuantizeSamples)
+
+	pixels := make(colorBox, 0, min(bounds.Dx()*bounds.Dy(), maxQuantizeSamples+1))
+	for i := 0; i < bounds.Dx()*bounds.Dy(); i += step {
+		x, y := bounds.Min.X+i%bounds.Dx(), bounds.Min.Y+i/bounds.Dx()
+		pixels = append(pixels, color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA))
+	}
+	return pixels
+}
+
+func channelValue(c color.NRGBA, channel int) uint8 {
+	switch channel {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	case 2:
+		return c.B
+	default:
+		return c.A
+	}
+}
+
+func (b colorBox) widestChannel() (int, int) {
+	widest, widestRange := 0, 0
+	for channel := 0; channel < 4; channel++ {
+		low, high := uint8(255), uint8(0)
+		for _, c := range b {
+			value := channelValue(c, channel)
+			low, high = min(low, value), max(high, value)
+		}
+		if int(high)-int(low) > widestRange {
+			widest, widestRange = channel, int(high)-int(low)
+		}
+	}
+	return widest, widestRange
+}
+
+func (b colorBox) average() color.NRGBA {
+	var r, g, bl, a int
+	for _, c := range b {
+		r, g, bl, a = r+int(c.R), g+int(c.G), bl+int(c.B), a+int(c.A)
+	}
+	n := len(b)
+	return color.NRGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: uint8(a / n)}
+}
+
+// quantize converts the image to a palette of up to colors entries, with Floyd-Steinberg dithering if requested
+func quantize(img image.Image, colors int, dither bool) *image.Paletted {
+	bounds := img.Bounds()
+	paletted := image.NewPaletted(bounds, medianCut{colors: colors}.Quantize(make(color.Palette, 0, colors), img))
+
+	var drawer draw.Drawer = draw.Src
+	if dither {
+		drawer = draw.FloydSteinberg
+	}
+	drawer.Draw(paletted, bounds, img, bounds.Min)
+
+	return paletted
+}
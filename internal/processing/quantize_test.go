@@ -0,0 +1,91 @@
+package processing
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// gradientImage has a different color in every pixel
+func gradientImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 255 / (width - 1)), G: uint8(y * 255 / (height - 1)), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func TestMedianCut(t *testing.T) {
+	tests := []struct {
+		name   string
+		img    image.Image
+		colors int
+		want   int
+	}{
+		{"fewer colors than the palette", quadrantImage(16, 16), 16, 4},
+		{"as many colors as the palette", quadrantImage(16, 16), 4, 4},
+		{"more colors than the palette", gradientImage(32, 32), 16, 16},
+		{"two colors", gradientImage(32, 32), 2, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			palette := medianCut{colors: tt.colors}.Quantize(nil, tt.img)
+			if len(palette) != tt.want {
+				t.Errorf("Quantize() returned %d colors, want %d", len(palette), tt.want)
+			}
+		})
+	}
+
+	full := color.Palette{color.Black, color.White}
+	if palette := (medianCut{colors: 2}).Quantize(full, gradientImage(8, 8)); len(palette) != 2 {
+		t.Errorf("Quantize() of a full palette returned %d colors, want 2", len(palette))
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	tests := []struct {
+		name   string
+		img    image.Image
+		colors int
+		dither bool
+		// maxError is the largest difference of a channel from the source that's allowed, on average
+		maxError int
+	}{
+		{"exact palette", quadrantImage(16, 16), 4, false, 0},
+		{"exact palette with dithering", quadrantImage(16, 16), 4, true, 0},
+		{"gradient", gradientImage(64, 64), 16, false, 12},
+		{"gradient with dithering", gradientImage(64, 64), 16, true, 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paletted := quantize(tt.img, tt.colors, tt.dither)
+
+			if paletted.Bounds() != tt.img.Bounds() {
+				t.Fatalf("bounds = %v, want %v", paletted.Bounds(), tt.img.Bounds())
+			}
+			if len(paletted.Palette) > tt.colors {
+				t.Errorf("palette of %d colors, want up to %d", len(paletted.Palette), tt.colors)
+			}
+			if got := meanChannelError(tt.img, paletted); got > tt.maxError {
+				t.Errorf("mean channel error = %d, want up to %d", got, tt.maxError)
+			}
+		})
+	}
+}
+
+func meanChannelError(a, b image.Image) int {
+	bounds := a.Bounds()
+	total := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ca := color.NRGBAModel.Convert(a.At(x, y)).(color.NRGBA)
+			cb := color.NRGBAModel.Convert(b.At(x, y)).(color.NRGBA)
+			total += diff(ca.R, cb.R) + diff(ca.G, cb.G) + diff(ca.B, cb.B) + diff(ca.A, cb.A)
+		}
+	}
+	return total / (bounds.Dx() * bounds.Dy() * 4)
+}
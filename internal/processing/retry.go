@@ -0,0 +1,20 @@
+package processing
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// retryDelay returns the backoff before the next attempt of a task that has already been tried attempts times.
+// The delay doubles with every attempt up to RetryMaxDelay, and a random half of it is jittered so tasks that
+// failed together (e.g. during a storage outage) don't all come back at once.
+func (s *Service) retryDelay(attempts int) time.Duration {
+	delay := s.config.RetryBaseDelay
+	for i := 1; i < attempts && delay < s.config.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, s.config.RetryMaxDelay)
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
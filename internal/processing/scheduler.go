@@ -0,0 +1,62 @@
+package processing
+
+import (
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+)
+
+// DefaultPriorityWeights gives interactive uploads most of the capacity while backfills still make progress.
+var DefaultPriorityWeights = map[model.TaskPriority]int{
+	model.PriorityHigh:   6,
+	model.PriorityNormal: 3,
+	model.PriorityLow:    1,
+}
+
+// scheduler splits the claim capacity of every poll between the priority levels with a smooth weighted round
+// robin. While all the levels have work each one gets a share proportional to its weight, so a large backlog
+// of low priority tasks can't starve the uploads and the low priority tasks are never starved either.
+// It is only used by the poller goroutine.
+type scheduler struct {
+	weights map[model.TaskPriority]int
+	current map[model.TaskPriority]int
+	total   int
+}
+
+func newScheduler(weights map[model.TaskPriority]int) *scheduler {
+	s := &scheduler{
+		weights: make(map[model.TaskPriority]int, len(model.Priorities)),
+		current: make(map[model.TaskPriority]int, len(model.Priorities)),
+	}
+
+	for _, priority := range model.Priorities {
+		weight, ok := weights[priority]
+		if !ok || weight <= 0 {
+			weight = 1
+		}
+		s.weights[priority] = weight
+		s.total += weight
+	}
+
+	return s
+}
+
+// allocate returns how many of the n slots each priority level should get. The state carries over between
+// calls, so the levels that were skipped in one poll are first in line in the next ones.
+func (s *scheduler) allocate(n int) map[model.TaskPriority]int {
+	quotas := make(map[model.TaskPriority]int, len(model.Priorities))
+
+	for range n {
+		var picked model.TaskPriority
+		best := 0
+		first := true
+		for _, priority := range model.Priorities {
+			s.current[priority] += s.weights[priority]
+			if first || s.current[priority] > best {
+				picked, best, first = priority, s.current[priority], false
+			}
+		}
+		s.current[picked] -= s.total
+		quotas[picked]++
+	}
+
+	return quotas
+}
@@ -2,19 +2,51 @@ package processing
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mahdi-vajdi/go-image-processor/internal/model"
 	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
 	"github.com/mahdi-vajdi/go-image-processor/internal/storage"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/sync/singleflight"
 )
 
 type ServiceConfig struct {
+	// WorkerPoolSize is the initial number of workers, the autoscaler keeps the pool between
+	// WorkerPoolMin and WorkerPoolMax
 	WorkerPoolSize  int
+	WorkerPoolMin   int
+	WorkerPoolMax   int
+	ScaleInterval   time.Duration
+	ScaleDownDelay  time.Duration
 	PollingInterval time.Duration
 	TaskBatchSize   int
+	// InstanceID identifies this instance as the owner of the tasks it claims
+	InstanceID     string
+	LeaseDuration  time.Duration
+	ReaperInterval time.Duration
+	// MaxAttempts is the number of times a task is tried before it's dead-lettered
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// PriorityWeights is the share of the claim capacity of each priority level, see scheduler
+	PriorityWeights map[model.TaskPriority]int
+	// Images over these limits are rejected before decoding
+	MaxImagePixels    int64
+	MaxImageDimension int
+	// MaxDecodeMemory is the estimated memory, in bytes, that all the images being processed can use
+	MaxDecodeMemory int64
+	// TransformCacheMemory is the size, in bytes, of the in-memory cache of on-demand transformations
+	TransformCacheMemory int64
+	// DerivedTTL is how long the stored outputs of on-demand transformations are kept
+	DerivedTTL time.Duration
+	// MinSSIM is the SSIM the quality of the renditions is chosen by when they don't set one, 0 keeps their quality
+	MinSSIM float64
 }
 
 type Service struct {
@@ -22,10 +54,35 @@ type Service struct {
 	storage storage.Storage
 	config  ServiceConfig
 
-	taskChan chan model.ImageProcessingTask
-	wg       sync.WaitGroup
-	ctx      context.Context
-	cancel   context.CancelFunc
+	taskChan  chan model.ImageProcessingTask
+	wakeChan  chan struct{}
+	scheduler *scheduler
+	decodeSem *semaphore.Weighted
+	wg        sync.WaitGroup
+
+	// Worker pool state used by the autoscaler
+	quitChan     chan struct{}
+	workers      atomic.Int32
+	busyWorkers  atomic.Int32
+	nextWorkerID atomic.Int32
+	ctx          context.Context
+	cancel       context.CancelFunc
+
+	// Cancel functions of the tasks being processed by this instance
+	runningMu sync.Mutex
+	running   map[int64]context.CancelCauseFunc
+
+	events *broker
+
+	// On-demand transformations, see Transform
+	transformCache *lruCache
+	transforms     singleflight.Group
+
+	// The background loops (poller, reaper and derived image cleaner) have their own lifecycle so they can be stopped
+	// before the workers drain the channel
+	loopWG     sync.WaitGroup
+	loopCtx    context.Context
+	loopCancel context.CancelFunc
 }
 
 func NewService(repo repository.Repository, storage storage.Storage, config ServiceConfig) *Service {
@@ -33,6 +90,21 @@ func NewService(repo repository.Repository, storage storage.Storage, config Serv
 		config.WorkerPoolSize = 5
 		log.Printf("Warning: WorkerPoolSize not set or invalid, defaulting to %d", config.WorkerPoolSize)
 	}
+	if config.WorkerPoolMin <= 0 {
+		config.WorkerPoolMin = config.WorkerPoolSize
+	}
+	if config.WorkerPoolMax < config.WorkerPoolMin {
+		config.WorkerPoolMax = max(config.WorkerPoolSize, config.WorkerPoolMin)
+	}
+	config.WorkerPoolSize = min(max(config.WorkerPoolSize, config.WorkerPoolMin), config.WorkerPoolMax)
+	if config.ScaleInterval <= 0 {
+		config.ScaleInterval = 5 * time.Second
+		log.Printf("Warning: ScaleInterval not set or invalid, defaulting to %s", config.ScaleInterval)
+	}
+	if config.ScaleDownDelay <= 0 {
+		config.ScaleDownDelay = time.Minute
+		log.Printf("Warning: ScaleDownDelay not set or invalid, defaulting to %s", config.ScaleDownDelay)
+	}
 	if config.PollingInterval <= 0 {
 		config.PollingInterval = 5 * time.Second
 		log.Printf("Warning: PollingInterval not set or invalid, defaulting to %s", config.PollingInterval)
@@ -41,16 +113,78 @@ func NewService(repo repository.Repository, storage storage.Storage, config Serv
 		config.TaskBatchSize = 10
 		log.Printf("Warning: TaskBatchSize not set or invalid, defaulting to %d", config.TaskBatchSize)
 	}
+	if config.InstanceID == "" {
+		hostname, _ := os.Hostname()
+		config.InstanceID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+		log.Printf("Warning: InstanceID not set, defaulting to %s", config.InstanceID)
+	}
+	if config.LeaseDuration <= 0 {
+		config.LeaseDuration = time.Minute
+		log.Printf("Warning: LeaseDuration not set or invalid, defaulting to %s", config.LeaseDuration)
+	}
+	if config.ReaperInterval <= 0 {
+		config.ReaperInterval = 30 * time.Second
+		log.Printf("Warning: ReaperInterval not set or invalid, defaulting to %s", config.ReaperInterval)
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+		log.Printf("Warning: MaxAttempts not set or invalid, defaulting to %d", config.MaxAttempts)
+	}
+	if config.RetryBaseDelay <= 0 {
+		config.RetryBaseDelay = 5 * time.Second
+		log.Printf("Warning: RetryBaseDelay not set or invalid, defaulting to %s", config.RetryBaseDelay)
+	}
+	if config.RetryMaxDelay < config.RetryBaseDelay {
+		config.RetryMaxDelay = max(10*time.Minute, config.RetryBaseDelay)
+		log.Printf("Warning: RetryMaxDelay not set or invalid, defaulting to %s", config.RetryMaxDelay)
+	}
+	if len(config.PriorityWeights) == 0 {
+		config.PriorityWeights = DefaultPriorityWeights
+	}
+	if config.MaxImagePixels <= 0 {
+		config.MaxImagePixels = 50_000_000
+		log.Printf("Warning: MaxImagePixels not set or invalid, defaulting to %d", config.MaxImagePixels)
+	}
+	if config.MaxImageDimension <= 0 {
+		config.MaxImageDimension = 16384
+		log.Printf("Warning: MaxImageDimension not set or invalid, defaulting to %d", config.MaxImageDimension)
+	}
+	if config.MaxDecodeMemory <= 0 {
+		config.MaxDecodeMemory = 1 << 30
+		log.Printf("Warning: MaxDecodeMemory not set or invalid, defaulting to %d", config.MaxDecodeMemory)
+	}
+	if config.TransformCacheMemory <= 0 {
+		config.TransformCacheMemory = 64 << 20
+		log.Printf("Warning: TransformCacheMemory not set or invalid, defaulting to %d", config.TransformCacheMemory)
+	}
+	if config.DerivedTTL <= 0 {
+		config.DerivedTTL = 7 * 24 * time.Hour
+		log.Printf("Warning: DerivedTTL not set or invalid, defaulting to %s", config.DerivedTTL)
+	}
+	if config.MinSSIM < 0 || config.MinSSIM >= 1 {
+		config.MinSSIM = 0
+		log.Printf("Warning: MinSSIM invalid, defaulting to 0 (fixed quality)")
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
+	loopCtx, loopCancel := context.WithCancel(context.Background())
 
 	return &Service{
-		repo:     repo,
-		storage:  storage,
-		config:   config,
-		taskChan: make(chan model.ImageProcessingTask, config.TaskBatchSize*2),
-		ctx:      ctx,
-		cancel:   cancel,
+		repo:           repo,
+		storage:        storage,
+		config:         config,
+		taskChan:       make(chan model.ImageProcessingTask, config.TaskBatchSize),
+		wakeChan:       make(chan struct{}, 1),
+		quitChan:       make(chan struct{}),
+		scheduler:      newScheduler(config.PriorityWeights),
+		decodeSem:      semaphore.NewWeighted(config.MaxDecodeMemory),
+		running:        make(map[int64]context.CancelCauseFunc),
+		events:         newBroker(),
+		transformCache: newLRUCache(config.TransformCacheMemory),
+		ctx:            ctx,
+		cancel:         cancel,
+		loopCtx:        loopCtx,
+		loopCancel:     loopCancel,
 	}
 }
 
@@ -58,16 +192,26 @@ func (s *Service) Start() {
 	log.Println("Starting image processing service...")
 
 	for i := 0; i < s.config.WorkerPoolSize; i++ {
-		s.wg.Add(1)
-		go s.worker(i + 1) // Pass the ID
+		s.startWorker()
 	}
 
-	log.Printf("Image processing service started with %d workers and polling every %s", s.config.WorkerPoolSize, s.config.PollingInterval)
+	s.loopWG.Add(4)
+	go s.poller()
+	go s.reaper()
+	go s.autoscaler()
+	go s.derivedCleaner()
+
+	log.Printf("Image processing service started with %d workers (min %d, max %d) and polling every %s",
+		s.config.WorkerPoolSize, s.config.WorkerPoolMin, s.config.WorkerPoolMax, s.config.PollingInterval)
 }
 
 func (s *Service) Stop(ctx context.Context) {
 	log.Println("stopping image processing service...")
 
+	// Stop claiming new tasks. The poller is the only sender, so the channel can be closed after it returns.
+	s.loopCancel()
+	s.loopWG.Wait()
+
 	// Signal workers to stop
 	close(s.taskChan)
 
@@ -83,16 +227,41 @@ func (s *Service) Stop(ctx context.Context) {
 		log.Println("All image processing tasks finished")
 	case <-ctx.Done():
 		log.Println("Image processing service shutdown context timed out. Some tasks may not have finished.")
+		// Abort the tasks that are still running
+		s.cancel()
 	}
 
 	log.Println("Image processing service stopped.")
 }
 
+// SubmitTask notifies the poller that a new task is pending so it doesn't wait for the next polling interval.
+// The task itself is claimed from the database like any other pending task.
 func (s *Service) SubmitTask(task model.ImageProcessingTask) {
+	log.Printf("task %d submitted, waking up the poller.", task.ID)
+	s.Wake()
+}
+
+// Cancel is called after a task was cancelled in the database. It aborts the task if it's being processed by this
+// instance and notifies the subscribers of its events. Tasks processed by other instances are aborted when their
+// heartbeat finds out they lost the lease.
+func (s *Service) Cancel(taskID int64) bool {
+	s.runningMu.Lock()
+	cancel, ok := s.running[taskID]
+	if ok {
+		cancel(ErrTaskCancelled)
+	}
+	s.runningMu.Unlock()
+
+	s.publish(Event{Type: EventStatus, TaskID: taskID, Status: model.StatusCancelled})
+
+	return ok
+}
+
+// Wake makes the poller look for pending tasks right away, e.g. after tasks were requeued.
+func (s *Service) Wake() {
 	select {
-	case s.taskChan <- task:
-		log.Printf("task %d submistted to processing channel.", task.ID)
-	case <-s.ctx.Done():
-		log.Printf("Could not submit task %d. Service is shutting down.", task.ID)
+	case s.wakeChan <- struct{}{}:
+	default:
+		// A wake up is already pending
 	}
 }
@@ -0,0 +1,112 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// minLimitQuality is the lowest quality tried to fit an image in its size limit, below it the image is made
+	// smaller instead since it usually looks better than heavy compression artifacts
+	minLimitQuality = 30
+	// minLimitDimension is the size of the longest side under which an image is not made smaller anymore
+	minLimitDimension = 16
+)
+
+// Encoded is an image encoded by a pipeline.
+type Encoded struct {
+	Data []byte
+	// Image is the image that was encoded, it's smaller than the input of the encoder if it had to be scaled down
+	Image image.Image
+	// Quality is the quality it was encoded with, 0 for lossless outputs
+	Quality int
+	// SSIM is the structural similarity of the output with Image, 0 if it wasn't measured
+	SSIM float64
+}
+
+// EncodeWithin encodes the image in at most maxBytes. The quality of the lossy formats is searched first, from the
+// quality of the pipeline down to minLimitQuality, and the image is scaled down when even that doesn't fit.
+func (p *Pipeline) EncodeWithin(ctx context.Context, img image.Image, maxBytes int64) (*Encoded, error) {
+	current := img
+	for {
+		encoded, err := p.encodeBestQuality(ctx, current, maxBytes)
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(encoded.Data)) <= maxBytes {
+			return encoded, nil
+		}
+
+		bounds := current.Bounds()
+		if max(bounds.Dx(), bounds.Dy()) <= minLimitDimension {
+			return nil, Permanent(fmt.Errorf("can't encode the image in %d bytes, the smallest output is %d bytes", maxBytes, len(encoded.Data)))
+		}
+
+		// The size of an image is roughly proportional to its area. The estimate is capped so a few steps are enough
+		// and floored so the image isn't made smaller than it needs to be.
+		scale := math.Sqrt(float64(maxBytes) / float64(len(encoded.Data)))
+		scale = min(max(scale, 0.5), 0.9)
+		width := max(1, int(float64(bounds.Dx())*scale))
+		height := max(1, int(float64(bounds.Dy())*scale))
+
+		// Always scale the input, so the image isn't resampled more than once
+		current = imaging.Resize(img, width, height, imaging.Lanczos)
+	}
+}
+
+// encodeBestQuality returns the output of the highest quality that fits in maxBytes, or the output of the lowest
+// quality tried if none does. Lossless outputs are encoded once.
+func (p *Pipeline) encodeBestQuality(ctx context.Context, img image.Image, maxBytes int64) (*Encoded, error) {
+	first, err := p.encodeWithQuality(ctx, img, p.Quality())
+	if err != nil || first.Quality == 0 || int64(len(first.Data)) <= maxBytes {
+		return first, err
+	}
+
+	// Binary search the qualities under the one of the pipeline, the size grows with the quality
+	low, high := minLimitQuality, first.Quality-1
+	var best *Encoded
+	smallest := first
+	for low <= high {
+		quality := (low + high) / 2
+		encoded, err := p.encodeWithQuality(ctx, img, quality)
+		if err != nil {
+			return nil, err
+		}
+
+		if int64(len(encoded.Data)) <= maxBytes {
+			best = encoded
+			low = quality + 1
+		} else {
+			smallest = encoded
+			high = quality - 1
+		}
+	}
+
+	if best == nil {
+		return smallest, nil
+	}
+	return best, nil
+}
+
+func (p *Pipeline) encodeWithQuality(ctx context.Context, img image.Image, quality int) (*Encoded, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	options := p.options
+	if quality > 0 {
+		options.Quality = quality
+	}
+
+	var buf bytes.Buffer
+	if err := p.codec.Encode(&buf, img, options); err != nil {
+		return nil, Permanent(err)
+	}
+
+	return &Encoded{Data: buf.Bytes(), Image: img, Quality: quality}, nil
+}
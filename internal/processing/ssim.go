@@ -0,0 +1,157 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// minAutoQuality is the lowest quality tried when the quality is chosen by SSIM
+	minAutoQuality = 10
+
+	// ssimWindow is the side of the square windows SSIM is averaged over, and ssimStep the distance between them
+	ssimWindow = 8
+	ssimStep   = 4
+
+	// lumaStripRows is the height of the strips luma converts at once, so it never copies the whole image
+	lumaStripRows = 64
+)
+
+// The stabilizing constants of SSIM for 8-bit values, (0.01*255)^2 and (0.03*255)^2
+const (
+	ssimC1 = 6.5025
+	ssimC2 = 58.5225
+)
+
+// EncodePerceptual encodes the image with the lowest quality whose output has at least minSSIM with the image,
+// between minAutoQuality and the quality of the pipeline. The output of the pipeline quality is returned when no lower
+// quality is good enough. It fails with image.ErrFormat when the binary can't decode the output format to measure it.
+func (p *Pipeline) EncodePerceptual(ctx context.Context, img image.Image, minSSIM float64) (*Encoded, error) {
+	reference := luma(img)
+
+	// Binary search the qualities, the similarity grows with the quality
+	var best *Encoded
+	low, high := minAutoQuality, p.Quality()
+	for low <= high {
+		quality := (low + high) / 2
+		encoded, err := p.encodeWithQuality(ctx, img, quality)
+		if err != nil {
+			return nil, err
+		}
+		if encoded.SSIM, err = measureSSIM(reference, encoded.Data); err != nil {
+			return nil, err
+		}
+
+		if encoded.SSIM >= minSSIM {
+			best = encoded
+			high = quality - 1
+		} else {
+			low = quality + 1
+		}
+	}
+
+	if best == nil {
+		encoded, err := p.encodeWithQuality(ctx, img, p.Quality())
+		if err != nil {
+			return nil, err
+		}
+		if encoded.SSIM, err = measureSSIM(reference, encoded.Data); err != nil {
+			return nil, err
+		}
+		return encoded, nil
+	}
+	return best, nil
+}
+
+// MeasureSSIM sets the SSIM of an encoded output with the image it was encoded from.
+func (e *Encoded) MeasureSSIM() error {
+	var err error
+	e.SSIM, err = measureSSIM(luma(e.Image), e.Data)
+	return err
+}
+
+func measureSSIM(reference *lumaImage, data []byte) (float64, error) {
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode the output to measure it: %w", err)
+	}
+	return ssim(reference, luma(decoded)), nil
+}
+
+// lumaImage is the 8-bit luma of an image, a quarter of the memory of the image itself
+type lumaImage struct {
+	width, height int
+	pix           []uint8
+}
+
+// luma returns the Rec. 601 luma of the image composed over black, like the encoders without alpha do. The image is
+// converted to NRGBA a strip of rows at a time.
+func luma(img image.Image) *lumaImage {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	l := &lumaImage{width: width, height: height, pix: make([]uint8, width*height)}
+	for y0 := 0; y0 < height; y0 += lumaStripRows {
+		strip := imaging.Crop(img, image.Rect(bounds.Min.X, bounds.Min.Y+y0, bounds.Max.X, bounds.Min.Y+min(y0+lumaStripRows, height)))
+		for y := 0; y < strip.Bounds().Dy(); y++ {
+			row := strip.Pix[y*strip.Stride:]
+			out := l.pix[(y0+y)*width:]
+			for x := 0; x < width; x++ {
+				r, g, b, a := float64(row[x*4]), float64(row[x*4+1]), float64(row[x*4+2]), float64(row[x*4+3])
+				out[x] = uint8((0.299*r+0.587*g+0.114*b)*a/255 + 0.5)
+			}
+		}
+	}
+	return l
+}
+
+// ssim returns the mean structural similarity of two images of the same size over overlapping windows, from 1 for
+// identical images down to 0 (or slightly below) for unrelated ones
+func ssim(a, b *lumaImage) float64 {
+	if a.width != b.width || a.height != b.height {
+		return 0
+	}
+
+	// Images smaller than a window are compared as a single window
+	window := min(ssimWindow, a.width, a.height)
+	if window == 0 {
+		return 1
+	}
+
+	var total float64
+	var windows int
+	for y := 0; y+window <= a.height; y += ssimStep {
+		for x := 0; x+window <= a.width; x += ssimStep {
+			total += windowSSIM(a, b, x, y, window)
+			windows++
+		}
+	}
+	return total / float64(windows)
+}
+
+func windowSSIM(a, b *lumaImage, x0, y0, window int) float64 {
+	var sumA, sumB, sumAA, sumBB, sumAB float64
+	for y := y0; y < y0+window; y++ {
+		for x := x0; x < x0+window; x++ {
+			va, vb := float64(a.pix[y*a.width+x]), float64(b.pix[y*b.width+x])
+			sumA += va
+			sumB += vb
+			sumAA += va * va
+			sumBB += vb * vb
+			sumAB += va * vb
+		}
+	}
+
+	n := float64(window * window)
+	meanA, meanB := sumA/n, sumB/n
+	varA := sumAA/n - meanA*meanA
+	varB := sumBB/n - meanB*meanB
+	covariance := sumAB/n - meanA*meanB
+
+	return ((2*meanA*meanB + ssimC1) * (2*covariance + ssimC2)) /
+		((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+}
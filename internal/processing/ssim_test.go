@@ -0,0 +1,94 @@
+package processing
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// noisy returns a copy of the image with every channel moved by up to amount, with a fixed seed
+func noisy(img *image.NRGBA, amount int) *image.NRGBA {
+	rng := rand.New(rand.NewSource(1))
+	out := imaging.Clone(img)
+	for i := range out.Pix {
+		if i%4 == 3 {
+			continue
+		}
+		value := int(out.Pix[i]) + rng.Intn(2*amount+1) - amount
+		out.Pix[i] = uint8(min(max(value, 0), 255))
+	}
+	return out
+}
+
+func TestSSIM(t *testing.T) {
+	src := gradientImage(64, 64)
+
+	tests := []struct {
+		name  string
+		other image.Image
+		low   float64
+		high  float64
+	}{
+		{"identical", imaging.Clone(src), 1, 1},
+		{"light noise", noisy(src, 8), 0.85, 0.98},
+		{"heavy noise", noisy(src, 96), 0, 0.3},
+		{"blurred", imaging.Blur(src, 2), 0.99, 0.9999},
+		{"different size", gradientImage(32, 32), 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ssim(luma(src), luma(tt.other))
+			if got < tt.low-1e-9 || got > tt.high+1e-9 {
+				t.Errorf("ssim() = %f, want between %f and %f", got, tt.low, tt.high)
+			}
+		})
+	}
+
+	light, heavy := ssim(luma(src), luma(noisy(src, 8))), ssim(luma(src), luma(noisy(src, 96)))
+	if heavy >= light {
+		t.Errorf("ssim() of heavy noise = %f, want less than %f for light noise", heavy, light)
+	}
+}
+
+func TestWindowSSIM(t *testing.T) {
+	a := &lumaImage{width: 8, height: 8, pix: make([]uint8, 64)}
+	b := &lumaImage{width: 8, height: 8, pix: make([]uint8, 64)}
+	for i := range a.pix {
+		a.pix[i] = uint8(i * 4)
+		b.pix[i] = uint8(255 - i*4)
+	}
+
+	if got := windowSSIM(a, a, 0, 0, 8); math.Abs(got-1) > 1e-9 {
+		t.Errorf("windowSSIM() of a window with itself = %f, want 1", got)
+	}
+	// The inverted window has the same mean and variance, but a negative covariance
+	if got := windowSSIM(a, b, 0, 0, 8); got >= 0 {
+		t.Errorf("windowSSIM() of an inverted window = %f, want a negative value", got)
+	}
+}
+
+func TestLuma(t *testing.T) {
+	// Taller than a strip, so the strips are stitched together
+	src := image.NewNRGBA(image.Rect(10, 20, 13, 20+lumaStripRows+5))
+	for y := src.Bounds().Min.Y; y < src.Bounds().Max.Y; y++ {
+		src.SetNRGBA(10, y, color.NRGBA{R: 255, A: 255})
+		src.SetNRGBA(11, y, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+		src.SetNRGBA(12, y, color.NRGBA{R: 255, G: 255, B: 255, A: uint8(y)})
+	}
+
+	l := luma(src)
+	if l.width != 3 || l.height != lumaStripRows+5 {
+		t.Fatalf("luma of %dx%d, want 3x%d", l.width, l.height, lumaStripRows+5)
+	}
+	for y := 0; y < l.height; y++ {
+		row := l.pix[y*3 : y*3+3]
+		if row[0] != 76 || row[1] != 255 || row[2] != uint8(20+y) {
+			t.Fatalf("row %d = %v, want [76 255 %d]", y, row, 20+y)
+		}
+	}
+}
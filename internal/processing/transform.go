@@ -0,0 +1,246 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
+)
+
+// Transformed is the encoded output of a synchronous transformation.
+type Transformed struct {
+	Data []byte
+	// Extension is the file extension (without the dot) of the output format
+	Extension string
+	Width     int
+	Height    int
+}
+
+// transformTimeout bounds a transformation, which isn't tied to the request that started it since concurrent
+// requests of the same variant wait for it too
+const transformTimeout = time.Minute
+
+// derivedNamePrefix is the storage name prefix of the cached transformation outputs, which are stored under the key
+// of their source so they can be deleted with it. The storage resolves the names under its own prefix.
+const derivedNamePrefix = "derived/"
+
+// derivedCleanupInterval is how often the derived images older than DerivedTTL are deleted
+const derivedCleanupInterval = time.Hour
+
+// Transform runs the operations on a stored image and returns the encoded result, without creating a task. It uses
+// the same pipeline as the workers and shares their decode memory budget, so on-demand transformations can't starve
+// the tasks being processed. Errors marked with Permanent are caused by the image or the operations.
+//
+// Only the recorded outputs of tasks can be transformed. Their transformations are cached under a key derived from
+// the image key and the operations: in memory, then in the storage. Concurrent requests of a variant that isn't cached
+// yet wait for a single transformation.
+func (s *Service) Transform(ctx context.Context, key string, ops model.Operations) (*Transformed, error) {
+	pipeline, err := NewPipeline(ops)
+	if err != nil {
+		return nil, fmt.Errorf("invalid operations: %w", Permanent(err))
+	}
+
+	// The caches outlive the image, so a purged image must not be served from them
+	if _, err = s.repo.GetProcessedImageByKey(ctx, key); err != nil {
+		if errors.Is(err, repository.ErrProcessedImageNotFound) {
+			return nil, fmt.Errorf("image %s was not found: %w", key, os.ErrNotExist)
+		}
+		return nil, err
+	}
+
+	derivedName, err := derivedStorageName(key, ops, pipeline.Extension())
+	if err != nil {
+		return nil, err
+	}
+	if transformed, ok := s.transformCache.get(derivedName); ok {
+		return transformed, nil
+	}
+
+	result := s.transforms.DoChan(derivedName, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), transformTimeout)
+		defer cancel()
+
+		return s.transformDerived(ctx, key, derivedName, pipeline)
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*Transformed), nil
+	}
+}
+
+// transformDerived returns the output stored under the derived name, or transforms the image and stores it there
+func (s *Service) transformDerived(ctx context.Context, key string, derivedName string, pipeline *Pipeline) (*Transformed, error) {
+	// Another flight may have finished between the cache lookup and this one
+	if transformed, ok := s.transformCache.get(derivedName); ok {
+		return transformed, nil
+	}
+
+	transformed, err := s.loadDerived(ctx, derivedName, pipeline.Extension())
+	if err != nil {
+		return nil, err
+	}
+
+	if transformed == nil {
+		transformed, err = s.transform(ctx, key, pipeline)
+		if err != nil {
+			return nil, err
+		}
+
+		// The output is still served if it can't be stored, the next request transforms it again
+		if err = s.storage.Put(ctx, derivedName, bytes.NewReader(transformed.Data)); err != nil {
+			log.Printf("Warning: failed to store transformed image %s of %s: %v", derivedName, key, err)
+		}
+	}
+
+	s.transformCache.add(derivedName, transformed)
+
+	return transformed, nil
+}
+
+// loadDerived reads a stored transformation output, it returns nil if there is none
+func (s *Service) loadDerived(ctx context.Context, derivedName string, extension string) (*Transformed, error) {
+	reader, err := s.storage.Get(ctx, s.storage.Key(derivedName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to download transformed image %s: %w", derivedName, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download transformed image %s: %w", derivedName, err)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// A corrupt output is replaced by transforming the image again
+		log.Printf("Warning: failed to read stored transformed image %s: %v", derivedName, err)
+		return nil, nil
+	}
+
+	return &Transformed{Data: data, Extension: extension, Width: cfg.Width, Height: cfg.Height}, nil
+}
+
+func (s *Service) transform(ctx context.Context, key string, pipeline *Pipeline) (*Transformed, error) {
+	reader, err := s.storage.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = Permanent(err)
+		}
+		return nil, fmt.Errorf("failed to download image %s: %w", key, err)
+	}
+	defer reader.Close()
+
+	original, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image %s: %w", key, err)
+	}
+
+	_, release, err := s.admit(ctx, original, &Plan{Base: pipeline})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	img, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", Permanent(err))
+	}
+
+	processed, err := pipeline.Apply(ctx, img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply operations: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err = pipeline.Encode(&buf, processed); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", Permanent(err))
+	}
+
+	return &Transformed{
+		Data:      buf.Bytes(),
+		Extension: pipeline.Extension(),
+		Width:     processed.Bounds().Dx(),
+		Height:    processed.Bounds().Dy(),
+	}, nil
+}
+
+// derivedStorageName is the storage name of the output of the operations on an image. The operations are hashed in
+// their JSON form, which has a fixed field order.
+func derivedStorageName(key string, ops model.Operations, extension string) (string, error) {
+	opsJSON, err := json.Marshal(ops)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal operations: %w", err)
+	}
+
+	hash := sha256.Sum256(opsJSON)
+
+	return derivedPrefix(key) + hex.EncodeToString(hash[:]) + "." + extension, nil
+}
+
+// derivedPrefix is the storage name prefix of the outputs of the transformations of an image
+func derivedPrefix(key string) string {
+	return derivedNamePrefix + key + "/"
+}
+
+// RemoveDerived deletes the stored and cached transformations of an image, it's called once the image is deleted.
+func (s *Service) RemoveDerived(ctx context.Context, key string) error {
+	prefix := derivedPrefix(key)
+	s.transformCache.removePrefix(prefix)
+
+	if _, err := s.storage.DeletePrefix(ctx, prefix, time.Now()); err != nil {
+		return fmt.Errorf("failed to delete the transformations of image %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// derivedCleaner deletes the derived images that are older than DerivedTTL. The popular ones are transformed and
+// stored again on their next request.
+func (s *Service) derivedCleaner() {
+	defer s.loopWG.Done()
+
+	ticker := time.NewTicker(derivedCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.loopCtx.Done():
+			log.Println("Derived image cleaner exiting.")
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(s.loopCtx, 10*time.Minute)
+		deleted, err := s.storage.DeletePrefix(ctx, derivedNamePrefix, time.Now().Add(-s.config.DerivedTTL))
+		cancel()
+
+		if err != nil {
+			log.Printf("Failed to delete expired derived images after deleting %d: %v", deleted, err)
+			continue
+		}
+
+		if deleted > 0 {
+			log.Printf("Deleted %d derived images older than %s", deleted, s.config.DerivedTTL)
+		}
+	}
+}
@@ -3,133 +3,381 @@ package processing
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
+	"io"
 	"log"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/disintegration/imaging"
 	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
 )
 
+// startWorker adds a worker to the pool
+func (s *Service) startWorker() {
+	s.wg.Add(1)
+	s.workers.Add(1)
+	go s.worker(int(s.nextWorkerID.Add(1)))
+}
+
 func (s *Service) worker(id int) {
 	defer s.wg.Done()
+	defer s.workers.Add(-1)
 
 	log.Printf("Worker #%d started", id)
 
-	for task := range s.taskChan {
-		log.Printf("Worker #%d processing task %s (original: %s)...", id, task.ID, task.OriginalFilename)
+	for {
+		select {
+		case task, ok := <-s.taskChan:
+			if !ok {
+				log.Printf("Worker #%d exiting.", id)
+				return
+			}
 
-		processingCtx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
-		err := s.repo.UpdateTaskStatus(processingCtx, task.ID, model.StatusProcessing, "")
-		cancel()
+			s.busyWorkers.Add(1)
+			s.handleTask(id, task)
+			s.busyWorkers.Add(-1)
 
-		if err != nil {
-			log.Printf("Worker #%d failed to set the task %s to 'procssing': %v", id, task.ID, err)
-			continue
+			// Tasks are only claimed for idle workers, so let the poller claim the next one
+			s.Wake()
+
+		case <-s.quitChan:
+			// The pool is being scaled down
+			log.Printf("Worker #%d exiting, pool scaled down.", id)
+			return
 		}
+	}
+}
 
-		// Initialize the processing
-		processErr := s.processTask(s.ctx, &task)
+func (s *Service) handleTask(workerID int, task model.ImageProcessingTask) {
+	// The task was moved to 'processing' when the poller claimed it. It may have waited in the channel for a
+	// while, so renew the lease first to make sure it wasn't reclaimed in the meantime, by another instance or
+	// by this one under a later attempt.
+	leaseCtx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	err := s.repo.ExtendLease(leaseCtx, task.ID, s.config.InstanceID, task.Attempts, s.config.LeaseDuration)
+	cancel()
 
-		// Update the task with the processing results
-		status := model.StatusCompleted
-		errorMessage := ""
-		if processErr != nil {
-			status = model.StatusFailed
-			errorMessage = processErr.Error()
+	if err != nil {
+		log.Printf("Worker #%d skipping task %d: %v", workerID, task.ID, err)
+		return
+	}
 
-			log.Printf("Worker #%d: Task %s failed: %v", id, task.ID, processErr)
-		} else {
-			log.Printf("Worker #%d: Task %d completed successfully", id, task.ID)
+	log.Printf("Worker #%d processing task %d (original: %s)...", workerID, task.ID, task.OriginalFilename)
+	s.publish(Event{Type: EventStatus, TaskID: task.ID, Status: model.StatusProcessing, Attempt: task.Attempts})
+
+	taskCtx, cancelTask := context.WithCancelCause(s.ctx)
+	s.runningMu.Lock()
+	s.running[task.ID] = cancelTask
+	s.runningMu.Unlock()
+
+	stopHeartbeat := s.heartbeat(task.ID, task.Attempts, func() { cancelTask(repository.ErrLeaseLost) })
+
+	// Initialize the processing
+	outputs, processErr := s.processTask(taskCtx, &task)
+
+	stopHeartbeat()
+	s.runningMu.Lock()
+	delete(s.running, task.ID)
+	s.runningMu.Unlock()
+	abortCause := context.Cause(taskCtx)
+	cancelTask(nil)
+
+	// A cancelled or reclaimed task no longer belongs to this instance, so its status is left alone
+	if processErr != nil && (errors.Is(abortCause, ErrTaskCancelled) || errors.Is(abortCause, repository.ErrLeaseLost)) {
+		log.Printf("Worker #%d: Task %d aborted: %v", workerID, task.ID, abortCause)
+		return
+	}
+
+	if processErr == nil {
+		s.completeTask(workerID, &task, outputs)
+		return
+	}
+
+	log.Printf("Worker #%d: Task %d failed on attempt %d: %v", workerID, task.ID, task.Attempts, processErr)
+
+	switch {
+	case IsPermanent(processErr):
+		s.finishTask(workerID, &task, model.StatusFailed, processErr.Error())
+	case task.Attempts >= s.config.MaxAttempts:
+		log.Printf("Worker #%d: Task %d ran out of attempts, moving it to dead letter", workerID, task.ID)
+		s.finishTask(workerID, &task, model.StatusDeadLetter, processErr.Error())
+	default:
+		s.retryTask(workerID, &task, processErr.Error())
+	}
+}
+
+// completeTask records the outputs of a task and marks it completed in a single transaction, so a task is never
+// completed without its outputs or the other way around. If that fails the stored files are removed, and the
+// task is processed again once its lease expires.
+func (s *Service) completeTask(workerID int, task *model.ImageProcessingTask, outputs []model.ProcessedImage) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := s.repo.WithTx(ctx, func(repo repository.Repository) error {
+		for i := range outputs {
+			if _, err := repo.CreateProcessedImageDetail(ctx, &outputs[i]); err != nil {
+				return fmt.Errorf("failed to save processed image detail: %w", err)
+			}
 		}
 
-		updateContext, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		err = s.repo.UpdateTaskStatus(updateContext, task.ID, status, errorMessage)
-		cancel()
+		return repo.FinishTask(ctx, task.ID, s.config.InstanceID, task.Attempts, model.StatusCompleted, "")
+	})
 
-		if err != nil {
-			// TODO: I need to create a cleanup process for this (maybe retry)
-			log.Printf("Worker #%d FATAL: failed to update task %s with final status '%s': %v", id, task.ID, status, err)
+	if err != nil {
+		s.removeOutputs(outputs)
+
+		if errors.Is(err, repository.ErrLeaseLost) {
+			log.Printf("Worker #%d: discarding the result of task %d, its lease was lost", workerID, task.ID)
+		} else {
+			// The lease will expire and the reaper will return the task to pending
+			log.Printf("Worker #%d FATAL: failed to complete task %d: %v", workerID, task.ID, err)
 		}
+		return
 	}
 
-	log.Printf("Worker #%d exiting.", id)
+	log.Printf("Worker #%d: Task %d completed successfully", workerID, task.ID)
+	s.publish(Event{Type: EventStatus, TaskID: task.ID, Status: model.StatusCompleted, Attempt: task.Attempts})
 }
 
-func (s *Service) processTask(ctx context.Context, task *model.ImageProcessingTask) error {
+func (s *Service) finishTask(workerID int, task *model.ImageProcessingTask, status model.TaskStatus, errorMessage string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err := s.repo.FinishTask(ctx, task.ID, s.config.InstanceID, task.Attempts, status, errorMessage)
+	cancel()
+
+	if errors.Is(err, repository.ErrLeaseLost) {
+		log.Printf("Worker #%d: discarding the result of task %d, its lease was lost", workerID, task.ID)
+	} else if err != nil {
+		// The lease will expire and the reaper will return the task to pending
+		log.Printf("Worker #%d FATAL: failed to update task %d with final status '%s': %v", workerID, task.ID, status, err)
+	} else {
+		s.publish(Event{Type: EventStatus, TaskID: task.ID, Status: status, Attempt: task.Attempts, Error: errorMessage})
+	}
+}
+
+func (s *Service) retryTask(workerID int, task *model.ImageProcessingTask, errorMessage string) {
+	delay := s.retryDelay(task.Attempts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err := s.repo.RetryTask(ctx, task.ID, s.config.InstanceID, task.Attempts, delay, errorMessage)
+	cancel()
+
+	if errors.Is(err, repository.ErrLeaseLost) {
+		log.Printf("Worker #%d: not retrying task %d, its lease was lost", workerID, task.ID)
+	} else if err != nil {
+		// The lease will expire and the reaper will return the task to pending
+		log.Printf("Worker #%d: failed to schedule a retry for task %d: %v", workerID, task.ID, err)
+	} else {
+		log.Printf("Worker #%d: Task %d will be retried in %s", workerID, task.ID, delay.Round(time.Second))
+		s.publish(Event{Type: EventStatus, TaskID: task.ID, Status: model.StatusPending, Attempt: task.Attempts, Error: errorMessage})
+	}
+}
+
+// processTask stores the renditions of a task and returns them, to be recorded by completeTask. On error, the
+// renditions that were already stored are removed.
+func (s *Service) processTask(ctx context.Context, task *model.ImageProcessingTask) ([]model.ProcessedImage, error) {
 	// Check if the context has been cancelled before starting or during long operations.
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return nil, ctx.Err()
 	default:
 		// Context is not done
 	}
 
-	log.Printf("Processing image for task %s (Storage key: %s)", task.ID, task.StorageKey)
+	log.Printf("Processing image for task %d (Storage key: %s)", task.ID, task.StorageKey)
 
 	// Download the file
 	originalImageReader, err := s.storage.Get(ctx, task.StorageKey)
 	if err != nil {
-		return fmt.Errorf("failed to download original image %s: %w", task.StorageKey, err)
+		if errors.Is(err, os.ErrNotExist) {
+			err = Permanent(err)
+		}
+		return nil, fmt.Errorf("failed to download original image %s: %w", task.StorageKey, err)
 	}
 	defer originalImageReader.Close()
 
+	// Read the whole file first, so an interrupted download isn't mistaken for a corrupt image
+	original, err := io.ReadAll(originalImageReader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download original image %s: %w", task.StorageKey, err)
+	}
+
+	plan, err := NewPlan(task)
+	if err != nil {
+		return nil, fmt.Errorf("invalid operations: %w", Permanent(err))
+	}
+
+	// Check the sizes from the header and wait for enough decode memory before decoding
+	_, release, err := s.admit(ctx, original, plan)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	// Decode the image
 	// Determine the image format based on the original file extension
-	img, format, err := image.Decode(originalImageReader)
+	img, format, err := image.Decode(bytes.NewReader(original))
 	if err != nil {
-		return fmt.Errorf("failed to decode image: %w", err)
+		return nil, fmt.Errorf("failed to decode image: %w", Permanent(err))
 	}
 
 	log.Printf("Image decoded successfully (Format: %s, Size: %dx%d)", format, img.Bounds().Dx(), img.Bounds().Dy())
 
-	// Resize the image
-	targetWidth := 800
-	resizedImage := imaging.Resize(img, targetWidth, 0, imaging.Lanczos)
+	// Run the shared operations once and then every rendition on their output
+	baseImage, err := plan.Base.Apply(ctx, img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply operations: %w", err)
+	}
+
+	// Outputs are recorded only after every rendition is stored, so a failed attempt can remove its files
+	// and the retry starts from scratch
+	var outputs []model.ProcessedImage
+	for i, rendition := range plan.Renditions {
+		output, err := s.processRendition(ctx, task, rendition, baseImage)
+		if err != nil {
+			s.removeOutputs(outputs)
+			return nil, fmt.Errorf("rendition %q: %w", rendition.Name, err)
+		}
+		outputs = append(outputs, *output)
+
+		s.publish(Event{
+			Type:      EventRendition,
+			TaskID:    task.ID,
+			Status:    model.StatusProcessing,
+			Attempt:   task.Attempts,
+			Rendition: rendition.Name,
+			Completed: i + 1,
+			Total:     len(plan.Renditions),
+		})
+	}
+
+	// Last checkpoint before the outputs are recorded
+	if ctx.Err() != nil {
+		s.removeOutputs(outputs)
+		return nil, fmt.Errorf("task aborted before saving its outputs: %w", context.Cause(ctx))
+	}
+
+	return outputs, nil
+}
+
+// removeOutputs deletes the stored files of outputs that won't be recorded
+func (s *Service) removeOutputs(outputs []model.ProcessedImage) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, output := range outputs {
+		if err := s.storage.Delete(ctx, output.StorageKey); err != nil {
+			log.Printf("Warning: failed to remove processed image %s of task %d: %v", output.StorageKey, output.TaskID, err)
+		}
+	}
+}
+
+func (s *Service) processRendition(ctx context.Context, task *model.ImageProcessingTask, rendition RenditionPipeline, img image.Image) (*model.ProcessedImage, error) {
+	processedImage, err := rendition.Apply(ctx, img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply operations: %w", err)
+	}
 
-	log.Printf("Image resized to %dx%d", resizedImage.Bounds().Dx(), resizedImage.Bounds().Dy())
+	log.Printf("Rendition %s of task %d processed to %dx%d", rendition.Name, task.ID, processedImage.Bounds().Dx(), processedImage.Bounds().Dy())
 
 	// Encode the processed image
-	var buf bytes.Buffer
-	outputFormat := imaging.JPEG // TODO: this can be configurable by params
+	minSSIM := rendition.MinSSIM
+	if minSSIM == 0 {
+		minSSIM = s.config.MinSSIM
+	}
+	encoded, err := encodeRendition(ctx, rendition, processedImage, minSSIM)
+	if err != nil {
+		return nil, err
+	}
+	if encoded.Image != processedImage {
+		log.Printf("Rendition %s of task %d scaled down to %dx%d to fit in %d bytes", rendition.Name, task.ID, encoded.Image.Bounds().Dx(), encoded.Image.Bounds().Dy(), rendition.MaxBytes)
+		processedImage = encoded.Image
+	}
+
 	originalExt := filepath.Ext(task.OriginalFilename)
 
 	// New filename
 	// FIXME: use a better naming structure
-	processedFilename := fmt.Sprintf("%s_%dx%d.%s",
+	processedFilename := fmt.Sprintf("%s_%s_%dx%d.%s",
 		strings.TrimSuffix(task.OriginalFilename, originalExt),
-		resizedImage.Bounds().Dx(),
-		resizedImage.Bounds().Dy(),
-		strings.ToLower(outputFormat.String()),
+		rendition.Name,
+		processedImage.Bounds().Dx(),
+		processedImage.Bounds().Dy(),
+		rendition.Extension(),
 	)
 
-	err = imaging.Encode(&buf, resizedImage, outputFormat)
-	if err != nil {
-		return fmt.Errorf("failed to encode processed image: %w", err)
+	// Don't upload anything for a task that was aborted while encoding
+	if err = ctx.Err(); err != nil {
+		return nil, err
 	}
 
 	// Upload the processed image
-	processedStorageKey, err := s.storage.Save(ctx, processedFilename, &buf)
+	processedStorageKey, err := s.storage.Save(ctx, processedFilename, bytes.NewReader(encoded.Data))
 	if err != nil {
-		return fmt.Errorf("failed to upload processed image %s: %w", processedFilename, err)
+		return nil, fmt.Errorf("failed to upload processed image %s: %w", processedFilename, err)
 	}
 
 	log.Printf("Processed image uploaded successfully with key: %s", processedStorageKey)
 
-	// Save the processedImage
-	processedImage := model.ProcessedImage{
+	return &model.ProcessedImage{
 		TaskID:     task.ID,
-		Format:     format,
-		Size:       fmt.Sprintf("%dx%d", resizedImage.Bounds().Dx(), resizedImage.Bounds().Dy()),
+		Rendition:  rendition.Name,
+		Format:     rendition.Extension(),
+		Size:       fmt.Sprintf("%dx%d", processedImage.Bounds().Dx(), processedImage.Bounds().Dy()),
+		Width:      processedImage.Bounds().Dx(),
+		Height:     processedImage.Bounds().Dy(),
+		ByteSize:   int64(len(encoded.Data)),
+		Quality:    encoded.Quality,
+		SSIM:       encoded.SSIM,
 		StorageKey: processedStorageKey,
+	}, nil
+}
+
+// encodeRendition encodes the output of a rendition. With a minSSIM, the quality is lowered to the lowest one that keeps
+// it, and with a size limit, the quality and then the dimensions are lowered until the output fits.
+func encodeRendition(ctx context.Context, rendition RenditionPipeline, img image.Image, minSSIM float64) (*Encoded, error) {
+	pipeline := rendition.Pipeline
+
+	var encoded *Encoded
+	if minSSIM > 0 && pipeline.Quality() > 0 {
+		var err error
+		encoded, err = pipeline.EncodePerceptual(ctx, img, minSSIM)
+		switch {
+		case errors.Is(err, image.ErrFormat):
+			// The output can't be measured without its decoder, e.g. AVIF
+			log.Printf("Warning: can't choose the quality of %s rendition %s by SSIM: %v", pipeline.Extension(), rendition.Name, err)
+		case err != nil:
+			return nil, fmt.Errorf("failed to encode processed image: %w", err)
+		default:
+			pipeline = pipeline.withQuality(encoded.Quality)
+		}
 	}
-	_, err = s.repo.CreateProcessedImageDetail(ctx, &processedImage)
-	if err != nil {
-		return fmt.Errorf("failed to save processed image detail: %w", err)
+
+	if rendition.MaxBytes > 0 && (encoded == nil || int64(len(encoded.Data)) > rendition.MaxBytes) {
+		var err error
+		encoded, err = pipeline.EncodeWithin(ctx, img, rendition.MaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode processed image within %d bytes: %w", rendition.MaxBytes, err)
+		}
+
+		// Record the similarity of the output that is actually stored
+		if minSSIM > 0 && encoded.Quality > 0 {
+			if err = encoded.MeasureSSIM(); err != nil && !errors.Is(err, image.ErrFormat) {
+				return nil, fmt.Errorf("failed to measure processed image: %w", err)
+			}
+		}
+	}
+
+	if encoded == nil {
+		var buf bytes.Buffer
+		if err := pipeline.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to encode processed image: %w", Permanent(err))
+		}
+		encoded = &Encoded{Data: buf.Bytes(), Image: img, Quality: pipeline.Quality()}
 	}
 
-	return nil
+	return encoded, nil
 }
@@ -0,0 +1,113 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+)
+
+// taskEventPayload is the payload of the outbox events of a task, built from the changed row
+const taskEventPayload = `json_build_object('task_id', id, 'status', status, 'priority', priority, 'attempts', attempts,
+	'error_message', error_message, 'original_filename', original_filename, 'updated_at', updated_at)`
+
+// terminalStatusList is model.TerminalStatuses as an SQL list
+var terminalStatusList = func() string {
+	statuses := make([]string, len(model.TerminalStatuses))
+	for i, status := range model.TerminalStatuses {
+		statuses[i] = "'" + string(status) + "'"
+	}
+	return strings.Join(statuses, ", ")
+}()
+
+// withTaskEvents wraps a statement that inserts or updates tasks so that, in the same statement, an outbox
+// event of eventType is written for every changed task, and a webhook delivery is queued for the changed tasks
+// that have a callback URL and ended up in a terminal status. The statement must not have a RETURNING clause,
+// result is the select list of the wrapped query over the changed rows, e.g. taskColumns or COUNT(*).
+func withTaskEvents(statement string, eventType model.OutboxEventType, result string) string {
+	return `
+		WITH changed AS (` + statement + `
+			RETURNING ` + taskColumns + `
+		), events AS (
+			INSERT INTO outbox_events (aggregate_id, event_type, payload) 
+			SELECT id, '` + string(eventType) + `', ` + taskEventPayload + ` 
+			FROM changed
+		), deliveries AS (
+			INSERT INTO webhook_deliveries (task_id, url, event) 
+			SELECT id, callback_url, status 
+			FROM changed 
+			WHERE callback_url <> '' AND status IN (` + terminalStatusList + `)
+		)
+		SELECT ` + result + ` FROM changed
+	`
+}
+
+func (r *Repository) PublishOutboxEvents(ctx context.Context, limit int, publish func(model.OutboxEvent) error) (int, error) {
+	var published []int64
+	var publishErr error
+
+	// The events published before a failure are still marked, so the transaction is committed either way
+	err := r.withTx(ctx, func(tx *Repository) error {
+		// The lock keeps the other relays away from these events until they are marked as published
+		var events []model.OutboxEvent
+		query := `
+			SELECT id, aggregate_id, event_type, payload, created_at, published_at 
+			FROM outbox_events 
+			WHERE published_at IS NULL 
+			ORDER BY id 
+			LIMIT $1 
+			FOR UPDATE SKIP LOCKED
+		`
+
+		if err := tx.q.SelectContext(ctx, &events, query, limit); err != nil {
+			return fmt.Errorf("failed to get unpublished outbox events: %w", err)
+		}
+
+		// Stop at the first failure so the events of a task are published in order
+		for _, event := range events {
+			if publishErr = publish(event); publishErr != nil {
+				break
+			}
+			published = append(published, event.ID)
+		}
+
+		if len(published) == 0 {
+			return nil
+		}
+
+		_, err := tx.q.ExecContext(ctx, `UPDATE outbox_events SET published_at = NOW() WHERE id = ANY($1)`, pq.Array(published))
+		if err != nil {
+			return fmt.Errorf("failed to mark outbox events as published: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if publishErr != nil {
+		return len(published), fmt.Errorf("failed to publish outbox event: %w", publishErr)
+	}
+
+	return len(published), nil
+}
+
+func (r *Repository) DeletePublishedOutboxEvents(ctx context.Context, olderThan time.Duration) (int64, error) {
+	query := `DELETE FROM outbox_events WHERE published_at < NOW() - make_interval(secs => $1)`
+
+	result, err := r.q.ExecContext(ctx, query, olderThan.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete published outbox events: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected after deleting published outbox events: %w", err)
+	}
+
+	return rowsAffected, nil
+}
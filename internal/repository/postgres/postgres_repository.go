@@ -5,21 +5,73 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/mahdi-vajdi/go-image-processor/internal/model"
 	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
 )
 
+// taskColumns is the column list used to scan a model.ImageProcessingTask
+const taskColumns = `id, original_filename, storage_key, status, error_message, operations, priority, claimed_by,
+	lease_expires_at, attempts, next_attempt_at, callback_url, owner, tags, created_at, updated_at`
+
+// processedImageColumns is the column list used to scan a model.ProcessedImage
+const processedImageColumns = `id, task_id, rendition, format, size, width, height, byte_size, quality, ssim,
+	storage_key, created_at, updated_at`
+
+// queryer is implemented by both *sqlx.DB and *sqlx.Tx, so the same methods run inside and outside transactions
+type queryer interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+	PrepareNamedContext(ctx context.Context, query string) (*sqlx.NamedStmt, error)
+}
+
 type Repository struct {
 	db *sqlx.DB
+	// q runs the queries, it's db or the transaction of a repository passed to a WithTx function
+	q  queryer
+	tx *sqlx.Tx
 }
 
 var _ repository.Repository = (*Repository)(nil)
 
 func NewRepository(db *sqlx.DB) *Repository {
-	return &Repository{db: db}
+	return &Repository{db: db, q: db}
+}
+
+func (r *Repository) WithTx(ctx context.Context, fn func(repo repository.Repository) error) error {
+	return r.withTx(ctx, func(tx *Repository) error {
+		return fn(tx)
+	})
+}
+
+// withTx runs fn with a repository bound to a new transaction, which is committed if fn succeeds. If r is
+// already bound to a transaction, fn joins it instead.
+func (r *Repository) withTx(ctx context.Context, fn func(tx *Repository) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err = fn(&Repository{db: r.db, q: tx, tx: tx}); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
 }
 
 func (r *Repository) CreateTask(ctx context.Context, task *model.ImageProcessingTask) (*model.ImageProcessingTask, error) {
@@ -28,13 +80,12 @@ func (r *Repository) CreateTask(ctx context.Context, task *model.ImageProcessing
 	task.UpdatedAt = now
 	task.Status = model.StatusPending
 
-	query := `
-		INSERT INTO image_processing_tasks (original_filename, storage_key, status, error_message, created_at, updated_at) 
-		VALUES (:original_filename, :storage_key, :status, :error_message, :created_at, :updated_at) 
-		RETURNING id, original_filename, storage_key, status, error_message, created_at, updated_at
-	`
+	query := withTaskEvents(`
+		INSERT INTO image_processing_tasks (original_filename, storage_key, status, error_message, operations, priority, callback_url, owner, tags, created_at, updated_at) 
+		VALUES (:original_filename, :storage_key, :status, :error_message, :operations, :priority, :callback_url, :owner, :tags, :created_at, :updated_at)`,
+		model.EventTaskCreated, taskColumns)
 
-	stmt, err := r.db.PrepareNamedContext(ctx, query)
+	stmt, err := r.q.PrepareNamedContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare named statement for task creation: %w", err)
 	}
@@ -48,40 +99,153 @@ func (r *Repository) CreateTask(ctx context.Context, task *model.ImageProcessing
 	return task, nil
 }
 
+func (r *Repository) CreateRenditions(ctx context.Context, task *model.ImageProcessingTask) error {
+	if len(task.Renditions) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO task_renditions (task_id, name, operations, max_bytes, min_ssim, created_at) 
+		VALUES (:task_id, :name, :operations, :max_bytes, :min_ssim, :created_at)
+		RETURNING id, task_id, name, operations, max_bytes, min_ssim, created_at
+	`
+
+	stmt, err := r.q.PrepareNamedContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare named statement for rendition creation: %w", err)
+	}
+	defer stmt.Close()
+
+	for i := range task.Renditions {
+		rendition := &task.Renditions[i]
+		rendition.TaskID = task.ID
+		rendition.CreatedAt = task.CreatedAt
+
+		if err = stmt.GetContext(ctx, rendition, rendition); err != nil {
+			return fmt.Errorf("failed to insert rendition %s for task %d: %w", rendition.Name, task.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// loadProcessedImages fills the processed images of the given tasks with a single query
+func (r *Repository) loadProcessedImages(ctx context.Context, tasks []model.ImageProcessingTask) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(tasks))
+	byID := make(map[int64]*model.ImageProcessingTask, len(tasks))
+	for i := range tasks {
+		ids[i] = tasks[i].ID
+		byID[tasks[i].ID] = &tasks[i]
+	}
+
+	var images []model.ProcessedImage
+	query := `SELECT ` + processedImageColumns + ` 
+		FROM processed_images 
+		WHERE task_id = ANY($1)
+		ORDER BY id
+	`
+
+	err := r.q.SelectContext(ctx, &images, query, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to get processed images: %w", err)
+	}
+
+	for _, image := range images {
+		task := byID[image.TaskID]
+		task.ProcessedImages = append(task.ProcessedImages, image)
+	}
+
+	return nil
+}
+
+// loadRenditions fills the renditions of the given tasks with a single query
+func (r *Repository) loadRenditions(ctx context.Context, tasks []model.ImageProcessingTask) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(tasks))
+	byID := make(map[int64]*model.ImageProcessingTask, len(tasks))
+	for i := range tasks {
+		ids[i] = tasks[i].ID
+		byID[tasks[i].ID] = &tasks[i]
+	}
+
+	var renditions []model.Rendition
+	query := `
+		SELECT id, task_id, name, operations, max_bytes, min_ssim, created_at 
+		FROM task_renditions 
+		WHERE task_id = ANY($1)
+		ORDER BY id
+	`
+
+	err := r.q.SelectContext(ctx, &renditions, query, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to get task renditions: %w", err)
+	}
+
+	for _, rendition := range renditions {
+		task := byID[rendition.TaskID]
+		task.Renditions = append(task.Renditions, rendition)
+	}
+
+	return nil
+}
+
 func (r *Repository) GetTaskByID(ctx context.Context, id int64) (*model.ImageProcessingTask, error) {
 	var task model.ImageProcessingTask
-	query := `
-		SELECT id, original_filename, storage_key, status, error_message, created_at, updated_at 
+	query := `SELECT ` + taskColumns + `
 		FROM image_processing_tasks 
 		WHERE id = $1
 	`
 
-	err := r.db.GetContext(ctx, &task, query, id)
+	err := r.q.GetContext(ctx, &task, query, id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("task with ID %s was not found: %w", id, repository.ErrTaskNotFound)
+			return nil, fmt.Errorf("task with ID %d was not found: %w", id, repository.ErrTaskNotFound)
 		}
-		return nil, fmt.Errorf("failed to get task by ID %s: %w", id, err)
+		return nil, fmt.Errorf("failed to get task by ID %d: %w", id, err)
 	}
 
-	return &task, nil
-}
+	tasks := []model.ImageProcessingTask{task}
+	if err = r.loadRenditions(ctx, tasks); err != nil {
+		return nil, err
+	}
 
-func (r *Repository) UpdateTaskStatus(ctx context.Context, id int64, status model.TaskStatus, errorMessage string) error {
-	query := `UPDATE image_processing_tasks SET status = $1, error_message = $2, updated_at = DEFAULT WHERE id = $3`
+	return &tasks[0], nil
+}
 
-	result, err := r.db.ExecContext(ctx, query, status, errorMessage, id)
+// GetTaskWithOutputs returns the task with its renditions and processed images
+func (r *Repository) GetTaskWithOutputs(ctx context.Context, id int64) (*model.ImageProcessingTask, error) {
+	task, err := r.GetTaskByID(ctx, id)
 	if err != nil {
-		return fmt.Errorf("failed to udpate task status with ID %s: %w", err)
+		return nil, err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	tasks := []model.ImageProcessingTask{*task}
+	if err = r.loadProcessedImages(ctx, tasks); err != nil {
+		return nil, err
+	}
+
+	return &tasks[0], nil
+}
+
+func (r *Repository) UpdateTaskStatus(ctx context.Context, id int64, status model.TaskStatus, errorMessage string) error {
+	query := withTaskEvents(`UPDATE image_processing_tasks SET status = $1, error_message = $2, updated_at = DEFAULT WHERE id = $3`,
+		model.EventTaskStatusChanged, `COUNT(*)`)
+
+	var updated int64
+	err := r.q.GetContext(ctx, &updated, query, status, errorMessage, id)
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected after updating task status for ID %s: %w", id, err)
+		return fmt.Errorf("failed to udpate task status with ID %d: %w", id, err)
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("no task status with ID %s was found to update", id)
+	if updated == 0 {
+		return fmt.Errorf("no task status with ID %d was found to update", id)
 	}
 
 	return nil
@@ -89,37 +253,216 @@ func (r *Repository) UpdateTaskStatus(ctx context.Context, id int64, status mode
 
 func (r *Repository) GetPendingTasks(ctx context.Context, limit int) ([]model.ImageProcessingTask, error) {
 	var tasks []model.ImageProcessingTask
-	query := `
-		SELECT id, original_filename, storage_key, status, created_at, updated_at 
+	query := `SELECT ` + taskColumns + `
 		FROM image_processing_tasks 
 		WHERE status = $1
 		ORDER BY created_at 
 		LIMIT $2
 	`
 
-	err := r.db.SelectContext(ctx, &tasks, query, model.StatusPending, limit)
+	err := r.q.SelectContext(ctx, &tasks, query, model.StatusPending, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pending tasks: %w", err)
 	}
 
+	if err = r.loadRenditions(ctx, tasks); err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+func (r *Repository) ClaimPendingTasks(ctx context.Context, owner string, lease time.Duration, priority model.TaskPriority, limit int) ([]model.ImageProcessingTask, error) {
+	var tasks []model.ImageProcessingTask
+	query := withTaskEvents(`
+		UPDATE image_processing_tasks 
+		SET status = $2, claimed_by = $3, lease_expires_at = NOW() + make_interval(secs => $4), 
+			attempts = attempts + 1, next_attempt_at = NULL, updated_at = DEFAULT 
+		WHERE id IN (
+			SELECT id 
+			FROM image_processing_tasks 
+			WHERE status = $1 AND priority = $6 AND (next_attempt_at IS NULL OR next_attempt_at <= NOW())
+			ORDER BY created_at 
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)`, model.EventTaskStatusChanged, taskColumns)
+
+	err := r.q.SelectContext(ctx, &tasks, query, model.StatusPending, model.StatusProcessing, owner, lease.Seconds(), limit, priority)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim pending tasks with %s priority: %w", priority, err)
+	}
+
+	// RETURNING doesn't keep the order of the sub query
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].CreatedAt.Before(tasks[j].CreatedAt) })
+
+	if err = r.loadRenditions(ctx, tasks); err != nil {
+		return nil, err
+	}
+
 	return tasks, nil
 }
 
+func (r *Repository) CountPendingTasks(ctx context.Context) (int, error) {
+	var count int
+	query := `
+		SELECT COUNT(*) 
+		FROM image_processing_tasks 
+		WHERE status = $1 AND (next_attempt_at IS NULL OR next_attempt_at <= NOW())
+	`
+
+	err := r.q.GetContext(ctx, &count, query, model.StatusPending)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pending tasks: %w", err)
+	}
+
+	return count, nil
+}
+
+func (r *Repository) ExtendLease(ctx context.Context, id int64, owner string, attempt int, lease time.Duration) error {
+	query := `
+		UPDATE image_processing_tasks 
+		SET lease_expires_at = NOW() + make_interval(secs => $1) 
+		WHERE id = $2 AND status = $3 AND claimed_by = $4 AND attempts = $5
+	`
+
+	result, err := r.q.ExecContext(ctx, query, lease.Seconds(), id, model.StatusProcessing, owner, attempt)
+	if err != nil {
+		return fmt.Errorf("failed to extend the lease of task %d: %w", id, err)
+	}
+
+	return checkLeaseUpdate(result, id)
+}
+
+func (r *Repository) FinishTask(ctx context.Context, id int64, owner string, attempt int, status model.TaskStatus, errorMessage string) error {
+	query := withTaskEvents(`
+		UPDATE image_processing_tasks 
+		SET status = $1, error_message = $2, claimed_by = '', lease_expires_at = NULL, updated_at = DEFAULT 
+		WHERE id = $3 AND status = $4 AND claimed_by = $5 AND attempts = $6`, model.EventTaskStatusChanged, `COUNT(*)`)
+
+	var finished int64
+	err := r.q.GetContext(ctx, &finished, query, status, errorMessage, id, model.StatusProcessing, owner, attempt)
+	if err != nil {
+		return fmt.Errorf("failed to finish task %d: %w", id, err)
+	}
+
+	return checkLeaseCount(finished, id)
+}
+
+func (r *Repository) RetryTask(ctx context.Context, id int64, owner string, attempt int, delay time.Duration, errorMessage string) error {
+	query := withTaskEvents(`
+		UPDATE image_processing_tasks 
+		SET status = $1, error_message = $2, next_attempt_at = NOW() + make_interval(secs => $3), 
+			claimed_by = '', lease_expires_at = NULL, updated_at = DEFAULT 
+		WHERE id = $4 AND status = $5 AND claimed_by = $6 AND attempts = $7`, model.EventTaskStatusChanged, `COUNT(*)`)
+
+	var retried int64
+	err := r.q.GetContext(ctx, &retried, query, model.StatusPending, errorMessage, delay.Seconds(), id, model.StatusProcessing, owner, attempt)
+	if err != nil {
+		return fmt.Errorf("failed to schedule a retry for task %d: %w", id, err)
+	}
+
+	return checkLeaseCount(retried, id)
+}
+
+func (r *Repository) ReleaseExpiredLeases(ctx context.Context, maxAttempts int) (int64, error) {
+	// A task that keeps losing its lease most likely crashes the worker, so it's dead-lettered like any
+	// other task that ran out of attempts
+	query := withTaskEvents(`
+		UPDATE image_processing_tasks 
+		SET status = CASE WHEN attempts >= $1 THEN $2 ELSE $3 END, 
+			error_message = CASE WHEN attempts >= $1 THEN 'lease expired on the last attempt' ELSE error_message END,
+			claimed_by = '', lease_expires_at = NULL, updated_at = DEFAULT 
+		WHERE status = $4 AND lease_expires_at < NOW()`, model.EventTaskStatusChanged, `COUNT(*)`)
+
+	var released int64
+	err := r.q.GetContext(ctx, &released, query, maxAttempts, model.StatusDeadLetter, model.StatusPending, model.StatusProcessing)
+	if err != nil {
+		return 0, fmt.Errorf("failed to release expired leases: %w", err)
+	}
+
+	return released, nil
+}
+
+func (r *Repository) CancelTask(ctx context.Context, id int64) (model.TaskStatus, error) {
+	var status model.TaskStatus
+
+	err := r.withTx(ctx, func(tx *Repository) error {
+		err := tx.q.GetContext(ctx, &status, `SELECT status FROM image_processing_tasks WHERE id = $1 FOR UPDATE`, id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return repository.ErrTaskNotFound
+			}
+			return fmt.Errorf("failed to get the status of task %d: %w", id, err)
+		}
+
+		if status != model.StatusPending && status != model.StatusProcessing {
+			return fmt.Errorf("task %d is %s: %w", id, status, repository.ErrTaskNotCancellable)
+		}
+
+		query := withTaskEvents(`
+			UPDATE image_processing_tasks 
+			SET status = $1, claimed_by = '', lease_expires_at = NULL, next_attempt_at = NULL, updated_at = DEFAULT 
+			WHERE id = $2`, model.EventTaskStatusChanged, `COUNT(*)`)
+
+		if _, err = tx.q.ExecContext(ctx, query, model.StatusCancelled, id); err != nil {
+			return fmt.Errorf("failed to cancel task %d: %w", id, err)
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, repository.ErrTaskNotCancellable) {
+		return "", err
+	}
+
+	return status, err
+}
+
+// checkLeaseUpdate turns an update that matched no rows into repository.ErrLeaseLost
+func checkLeaseUpdate(result sql.Result, id int64) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected after updating the lease of task %d: %w", id, err)
+	}
+
+	return checkLeaseCount(rowsAffected, id)
+}
+
+func checkLeaseCount(updated int64, id int64) error {
+	if updated == 0 {
+		return fmt.Errorf("task %d is no longer claimed by this instance: %w", id, repository.ErrLeaseLost)
+	}
+
+	return nil
+}
+
 func (r *Repository) CreateProcessedImageDetail(ctx context.Context, detail *model.ProcessedImage) (*model.ProcessedImage, error) {
 	now := time.Now()
 	detail.CreatedAt = now
 	detail.UpdatedAt = now
 
 	query := `
-		INSERT INTO processed_images (task_id, format, size, storage_key, created_at, updated_at) 
-		VALUES (:task_id, :format, :size, :storage_key, :created_at, :updated_at)
-		RETURNING id, task_id, format, size, storage_key, created_at, updated_at
+		WITH created AS (
+			INSERT INTO processed_images (task_id, rendition, format, size, width, height, byte_size, quality, 
+				ssim, storage_key, created_at, updated_at) 
+			VALUES (:task_id, :rendition, :format, :size, :width, :height, :byte_size, :quality, 
+				:ssim, :storage_key, :created_at, :updated_at)
+			RETURNING ` + processedImageColumns + `
+		), events AS (
+			INSERT INTO outbox_events (aggregate_id, event_type, payload) 
+			SELECT task_id, '` + string(model.EventImageProcessed) + `', json_build_object('task_id', task_id, 'processed_image_id', id, 
+				'rendition', rendition, 'format', format, 'size', size, 'width', width, 'height', height, 
+				'byte_size', byte_size, 'quality', quality, 'ssim', ssim, 
+				'storage_key', storage_key) 
+			FROM created
+		)
+		SELECT ` + processedImageColumns + ` FROM created
 	`
 
-	stmt, err := r.db.PrepareNamedContext(ctx, query)
+	stmt, err := r.q.PrepareNamedContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare named statement for task detail creation: %w", err)
 	}
+	defer stmt.Close()
 
 	err = stmt.GetContext(ctx, detail, detail)
 	if err != nil {
@@ -129,3 +472,222 @@ func (r *Repository) CreateProcessedImageDetail(ctx context.Context, detail *mod
 	return detail, nil
 
 }
+
+func (r *Repository) ListProcessedImages(ctx context.Context, taskID int64) ([]model.ProcessedImage, error) {
+	var images []model.ProcessedImage
+	query := `
+		SELECT ` + processedImageColumns + ` 
+		FROM processed_images 
+		WHERE task_id = $1 
+		ORDER BY id
+	`
+
+	err := r.q.SelectContext(ctx, &images, query, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get processed images of task %d: %w", taskID, err)
+	}
+
+	return images, nil
+}
+
+func (r *Repository) GetProcessedImageByKey(ctx context.Context, storageKey string) (*model.ProcessedImage, error) {
+	var image model.ProcessedImage
+	query := `SELECT ` + processedImageColumns + ` 
+		FROM processed_images 
+		WHERE storage_key = $1
+	`
+
+	err := r.q.GetContext(ctx, &image, query, storageKey)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("processed image with key %s was not found: %w", storageKey, repository.ErrProcessedImageNotFound)
+		}
+		return nil, fmt.Errorf("failed to get processed image by key %s: %w", storageKey, err)
+	}
+
+	return &image, nil
+}
+
+// taskFilterConditions builds the WHERE clause of a repository.TaskFilter
+func taskFilterConditions(filter repository.TaskFilter) (string, []any) {
+	var conditions []string
+	var args []any
+
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(filter.Statuses) > 0 {
+		statuses := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			statuses[i] = string(status)
+		}
+		conditions = append(conditions, "status = ANY("+arg(pq.Array(statuses))+")")
+	}
+	if filter.ErrorContains != "" {
+		conditions = append(conditions, "error_message ILIKE '%' || "+arg(escapeLike(filter.ErrorContains))+" || '%'")
+	}
+	if filter.UpdatedAfter != nil {
+		conditions = append(conditions, "updated_at >= "+arg(filter.UpdatedAfter.UTC()))
+	}
+	if filter.UpdatedBefore != nil {
+		conditions = append(conditions, "updated_at < "+arg(filter.UpdatedBefore.UTC()))
+	}
+	if filter.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= "+arg(filter.CreatedAfter.UTC()))
+	}
+	if filter.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < "+arg(filter.CreatedBefore.UTC()))
+	}
+	if filter.FilenamePrefix != "" {
+		conditions = append(conditions, "original_filename LIKE "+arg(escapeLike(filter.FilenamePrefix)+"%"))
+	}
+	if filter.Owner != "" {
+		conditions = append(conditions, "owner = "+arg(filter.Owner))
+	}
+	if len(filter.Tags) > 0 {
+		conditions = append(conditions, "tags @> "+arg(model.Tags(filter.Tags)))
+	}
+
+	if len(conditions) == 0 {
+		return "TRUE", args
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+func (r *Repository) ListTasks(ctx context.Context, filter repository.TaskFilter) ([]model.ImageProcessingTask, error) {
+	where, args := taskFilterConditions(filter)
+
+	query := `SELECT ` + taskColumns + `
+		FROM image_processing_tasks 
+		WHERE ` + where + `
+		ORDER BY updated_at DESC, id DESC`
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	tasks := []model.ImageProcessingTask{}
+	err := r.q.SelectContext(ctx, &tasks, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+
+	if err = r.loadRenditions(ctx, tasks); err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+func (r *Repository) SearchTasks(ctx context.Context, search repository.TaskSearch) ([]model.ImageProcessingTask, error) {
+	where, args := taskFilterConditions(search.Filter)
+
+	column := "created_at"
+	if search.Sort.Field == repository.SortByUpdatedAt {
+		column = "updated_at"
+	}
+	direction, comparison := "ASC", ">"
+	if search.Sort.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if search.After != nil {
+		// The cursor is compared as a TIMESTAMP literal so the session time zone can't shift it
+		args = append(args, search.After.Time.Format("2006-01-02 15:04:05.999999"), search.After.ID)
+		where += fmt.Sprintf(" AND (%s, id) %s ($%d::timestamp, $%d)", column, comparison, len(args)-1, len(args))
+	}
+
+	args = append(args, search.Limit)
+	query := `SELECT ` + taskColumns + `
+		FROM image_processing_tasks 
+		WHERE ` + where + fmt.Sprintf(`
+		ORDER BY %[1]s %[2]s, id %[2]s 
+		LIMIT $%[3]d`, column, direction, len(args))
+
+	tasks := []model.ImageProcessingTask{}
+	err := r.q.SelectContext(ctx, &tasks, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search tasks: %w", err)
+	}
+
+	if err = r.loadRenditions(ctx, tasks); err != nil {
+		return nil, err
+	}
+	if err = r.loadProcessedImages(ctx, tasks); err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+func (r *Repository) CountTasks(ctx context.Context, filter repository.TaskFilter) (int64, error) {
+	where, args := taskFilterConditions(filter)
+
+	var count int64
+	err := r.q.GetContext(ctx, &count, `SELECT COUNT(*) FROM image_processing_tasks WHERE `+where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count tasks: %w", err)
+	}
+
+	return count, nil
+}
+
+func (r *Repository) RequeueTasks(ctx context.Context, ids []int64) (int64, error) {
+	query := withTaskEvents(`
+		UPDATE image_processing_tasks 
+		SET status = $1, error_message = '', attempts = 0, next_attempt_at = NULL, updated_at = DEFAULT 
+		WHERE id = ANY($2) AND status = ANY($3)`, model.EventTaskStatusChanged, `COUNT(*)`)
+
+	var requeued int64
+	requeueable := pq.Array([]string{string(model.StatusFailed), string(model.StatusDeadLetter)})
+	err := r.q.GetContext(ctx, &requeued, query, model.StatusPending, pq.Array(ids), requeueable)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue tasks: %w", err)
+	}
+
+	return requeued, nil
+}
+
+func (r *Repository) PurgeTasks(ctx context.Context, filter repository.TaskFilter) (int64, []string, error) {
+	where, args := taskFilterConditions(filter)
+
+	var storageKeys, originalKeys []string
+
+	err := r.withTx(ctx, func(tx *Repository) error {
+		var ids []int64
+		err := tx.q.SelectContext(ctx, &ids, `SELECT id FROM image_processing_tasks WHERE `+where+` FOR UPDATE`, args...)
+		if err != nil {
+			return fmt.Errorf("failed to select tasks to purge: %w", err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		err = tx.q.SelectContext(ctx, &storageKeys, `DELETE FROM processed_images WHERE task_id = ANY($1) RETURNING storage_key`, pq.Array(ids))
+		if err != nil {
+			return fmt.Errorf("failed to purge processed images: %w", err)
+		}
+
+		err = tx.q.SelectContext(ctx, &originalKeys, `DELETE FROM image_processing_tasks WHERE id = ANY($1) RETURNING storage_key`, pq.Array(ids))
+		if err != nil {
+			return fmt.Errorf("failed to purge tasks: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return int64(len(originalKeys)), append(storageKeys, originalKeys...), nil
+}
@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+)
+
+// The payload is written by the sender and isn't read back
+const webhookDeliveryColumns = `id, task_id, url, event, status, attempts, response_status, last_error, next_attempt_at,
+	created_at, updated_at`
+
+func (r *Repository) ClaimWebhookDeliveries(ctx context.Context, lease time.Duration, limit int) ([]model.WebhookDelivery, error) {
+	// Pushing next_attempt_at past the lease hides the claimed deliveries from the other instances, and makes
+	// them due again if this one crashes before recording the outcome
+	query := `
+		WITH due AS (
+			SELECT id AS due_id 
+			FROM webhook_deliveries 
+			WHERE status = $1 AND next_attempt_at <= NOW() 
+			ORDER BY next_attempt_at 
+			LIMIT $2 
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_deliveries 
+		SET attempts = attempts + 1, next_attempt_at = NOW() + make_interval(secs => $3), updated_at = DEFAULT 
+		FROM due 
+		WHERE id = due.due_id 
+		RETURNING ` + webhookDeliveryColumns
+
+	var deliveries []model.WebhookDelivery
+	err := r.q.SelectContext(ctx, &deliveries, query, model.DeliveryPending, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+func (r *Repository) UpdateWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery, retryDelay time.Duration) error {
+	query := `
+		UPDATE webhook_deliveries 
+		SET status = $1, payload = $2, response_status = $3, last_error = $4, 
+			next_attempt_at = NOW() + make_interval(secs => $5), updated_at = DEFAULT 
+		WHERE id = $6
+	`
+
+	_, err := r.q.ExecContext(ctx, query, delivery.Status, delivery.Payload, delivery.ResponseStatus, delivery.LastError,
+		retryDelay.Seconds(), delivery.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery %d: %w", delivery.ID, err)
+	}
+
+	return nil
+}
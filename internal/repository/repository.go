@@ -3,20 +3,151 @@ package repository
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/mahdi-vajdi/go-image-processor/internal/model"
 )
 
 type Repository interface {
+	// WithTx runs fn with a repository whose methods share a single transaction, which is committed if fn
+	// returns nil and rolled back otherwise. Calling WithTx on the repository passed to fn joins its transaction.
+	WithTx(ctx context.Context, fn func(repo Repository) error) error
+
 	CreateTask(ctx context.Context, task *model.ImageProcessingTask) (*model.ImageProcessingTask, error)
 
+	// CreateRenditions stores the renditions of a created task.
+	CreateRenditions(ctx context.Context, task *model.ImageProcessingTask) error
+
 	GetTaskByID(ctx context.Context, id int64) (*model.ImageProcessingTask, error)
 
+	// GetTaskWithOutputs returns a task together with the processed images it produced.
+	GetTaskWithOutputs(ctx context.Context, id int64) (*model.ImageProcessingTask, error)
+
 	UpdateTaskStatus(ctx context.Context, id int64, status model.TaskStatus, errorMessage string) error
 
 	GetPendingTasks(ctx context.Context, limit int) ([]model.ImageProcessingTask, error)
 
+	// ClaimPendingTasks atomically moves up to limit pending tasks of the given priority to processing under a
+	// lease held by owner and returns them, oldest first. Tasks that are being claimed by another instance are
+	// skipped.
+	ClaimPendingTasks(ctx context.Context, owner string, lease time.Duration, priority model.TaskPriority, limit int) ([]model.ImageProcessingTask, error)
+
+	// ExtendLease renews the lease of a processing task. It returns ErrLeaseLost if owner no longer holds it.
+	//
+	// The lease methods identify a claim by its owner and attempt, the attempts of the task when it was claimed,
+	// so an instance that claimed the same task twice can't use the first claim anymore.
+	ExtendLease(ctx context.Context, id int64, owner string, attempt int, lease time.Duration) error
+
+	// FinishTask sets the final status of a task and releases its lease. It returns ErrLeaseLost if owner
+	// no longer holds it.
+	FinishTask(ctx context.Context, id int64, owner string, attempt int, status model.TaskStatus, errorMessage string) error
+
+	// CountPendingTasks returns the number of pending tasks that can be claimed now.
+	CountPendingTasks(ctx context.Context) (int, error)
+
+	// RetryTask returns a processing task to pending, to be claimed again once delay has passed. It returns
+	// ErrLeaseLost if owner no longer holds the task.
+	RetryTask(ctx context.Context, id int64, owner string, attempt int, delay time.Duration, errorMessage string) error
+
+	// ReleaseExpiredLeases returns the processing tasks whose lease has expired to pending, or moves them to
+	// dead letter if they already used maxAttempts.
+	ReleaseExpiredLeases(ctx context.Context, maxAttempts int) (int64, error)
+
+	// CancelTask moves a pending or processing task to cancelled and returns the status it had. A processing
+	// task loses its lease, so the instance working on it aborts it. It returns ErrTaskNotCancellable if the
+	// task has already finished.
+	CancelTask(ctx context.Context, id int64) (model.TaskStatus, error)
+
 	CreateProcessedImageDetail(ctx context.Context, detail *model.ProcessedImage) (*model.ProcessedImage, error)
+
+	// ListProcessedImages returns the outputs of a task in the order they were recorded.
+	ListProcessedImages(ctx context.Context, taskID int64) ([]model.ProcessedImage, error)
+
+	// GetProcessedImageByKey returns the output stored under the storage key.
+	GetProcessedImageByKey(ctx context.Context, storageKey string) (*model.ProcessedImage, error)
+
+	// ListTasks returns the tasks matching the filter, most recently updated first.
+	ListTasks(ctx context.Context, filter TaskFilter) ([]model.ImageProcessingTask, error)
+
+	// SearchTasks returns a page of the tasks matching the filter of search, in its sort order.
+	SearchTasks(ctx context.Context, search TaskSearch) ([]model.ImageProcessingTask, error)
+
+	// CountTasks returns the number of tasks matching the filter.
+	CountTasks(ctx context.Context, filter TaskFilter) (int64, error)
+
+	// RequeueTasks returns the given failed or dead-lettered tasks to pending with a fresh set of attempts.
+	// Tasks in any other status are left untouched.
+	RequeueTasks(ctx context.Context, ids []int64) (int64, error)
+
+	// PurgeTasks deletes the tasks matching the filter together with their outputs. It returns the number of
+	// deleted tasks and the storage keys that are no longer referenced.
+	PurgeTasks(ctx context.Context, filter TaskFilter) (int64, []string, error)
+
+	// ClaimWebhookDeliveries returns up to limit pending deliveries that are due and hides them from the other
+	// instances for the duration of lease, after which they are due again unless updated.
+	ClaimWebhookDeliveries(ctx context.Context, lease time.Duration, limit int) ([]model.WebhookDelivery, error)
+
+	// UpdateWebhookDelivery records the outcome of a delivery attempt. A delivery that is still pending is due
+	// again after retryDelay.
+	UpdateWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery, retryDelay time.Duration) error
+
+	// PublishOutboxEvents calls publish for up to limit unpublished outbox events, oldest first, and marks the
+	// ones that were published. It stops at the first error, which is returned with the number of published
+	// events. The events are locked meanwhile, so concurrent relays publish different events.
+	PublishOutboxEvents(ctx context.Context, limit int, publish func(model.OutboxEvent) error) (int, error)
+
+	// DeletePublishedOutboxEvents deletes the events that were published more than olderThan ago.
+	DeletePublishedOutboxEvents(ctx context.Context, olderThan time.Duration) (int64, error)
+}
+
+// TaskFilter narrows down the tasks of ListTasks, SearchTasks, CountTasks and PurgeTasks. Zero values don't
+// filter. Limit and Offset only apply to ListTasks.
+type TaskFilter struct {
+	Statuses       []model.TaskStatus
+	ErrorContains  string
+	UpdatedAfter   *time.Time
+	UpdatedBefore  *time.Time
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	FilenamePrefix string
+	Owner          string
+	// Tags matches the tasks that have all of them
+	Tags   []string
+	Limit  int
+	Offset int
+}
+
+type TaskSortField string
+
+const (
+	SortByCreatedAt TaskSortField = "created_at"
+	SortByUpdatedAt TaskSortField = "updated_at"
+)
+
+type TaskSort struct {
+	Field      TaskSortField
+	Descending bool
+}
+
+// TaskCursor is a position in a sorted list of tasks: the sorted time and the ID of the last task of a page.
+type TaskCursor struct {
+	Time time.Time
+	ID   int64
+}
+
+// TaskSearch is a keyset paginated query of SearchTasks. The next page starts After the last task of the
+// previous one, which stays stable while tasks are added, unlike an offset.
+type TaskSearch struct {
+	Filter TaskFilter
+	Sort   TaskSort
+	After  *TaskCursor
+	Limit  int
 }
 
 var ErrTaskNotFound = errors.New("repository: task not found")
+
+var ErrProcessedImageNotFound = errors.New("repository: processed image not found")
+
+var ErrLeaseLost = errors.New("repository: task lease lost")
+
+var ErrTaskNotCancellable = errors.New("repository: task can no longer be cancelled")
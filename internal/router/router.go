@@ -6,17 +6,28 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/mahdi-vajdi/go-image-processor/internal/handler"
 	"github.com/mahdi-vajdi/go-image-processor/internal/middleware"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/auth"
+	"github.com/mahdi-vajdi/go-image-processor/internal/platform/signedurl"
 )
 
 type Router struct {
 	*mux.Router
 	handler handler.Handler
+	// signer verifies the URLs of the image downloads and transformations, nil disables the verification
+	signer *signedurl.Signer
+	// apiKeys identify the owners of the clients of the image API
+	apiKeys *auth.Keys
+	// adminKeys are the bearer tokens of the admin API, it's disabled without keys
+	adminKeys []string
 }
 
-func New(handler handler.Handler) *Router {
+func New(handler handler.Handler, signer *signedurl.Signer, apiKeys *auth.Keys, adminKeys []string) *Router {
 	r := &Router{
-		Router:  mux.NewRouter(),
-		handler: handler,
+		Router:    mux.NewRouter(),
+		handler:   handler,
+		signer:    signer,
+		apiKeys:   apiKeys,
+		adminKeys: adminKeys,
 	}
 
 	r.Use(middleware.Logging)
@@ -36,9 +47,26 @@ func (r *Router) setupRoutes() {
 	publicApiV1.HandleFunc("/ping", r.handler.Ping).Methods(http.MethodGet)
 
 	imageApiV1 := apiV1.PathPrefix("/image").Subrouter()
+	imageApiV1.Use(middleware.Authenticate(r.apiKeys, r.adminKeys))
 	imageApiV1.HandleFunc("/upload", r.handler.UploadImage).Methods(http.MethodPost)
 	imageApiV1.HandleFunc("/status/{taskId}", r.handler.GetImageStatus).Methods(http.MethodGet)
-	imageApiV1.HandleFunc("/{imageKey}", r.handler.GetImage).Methods(http.MethodGet)
+	imageApiV1.HandleFunc("/status/{taskId}/events", r.handler.StreamTaskEvents).Methods(http.MethodGet)
+	imageApiV1.HandleFunc("/tasks", r.handler.SearchTasks).Methods(http.MethodGet)
+	imageApiV1.HandleFunc("/tasks/{taskId}", r.handler.CancelTask).Methods(http.MethodDelete)
+	imageApiV1.HandleFunc("/tasks/{taskId}/urls", r.handler.SignImageURL).Methods(http.MethodPost)
+
+	// Images are only served with a signed URL
+	signedImageApiV1 := imageApiV1.Methods(http.MethodGet).Subrouter()
+	signedImageApiV1.Use(middleware.SignedURL(r.signer))
+	signedImageApiV1.HandleFunc("/{imageKey}", r.handler.GetImage)
+	signedImageApiV1.HandleFunc("/{imageKey}/transform", r.handler.TransformImage)
+
+	adminApiV1 := apiV1.PathPrefix("/admin").Subrouter()
+	adminApiV1.Use(middleware.AdminAuth(r.adminKeys))
+	adminApiV1.HandleFunc("/tasks", r.handler.SearchTasks).Methods(http.MethodGet)
+	adminApiV1.HandleFunc("/tasks/failed", r.handler.ListFailedTasks).Methods(http.MethodGet)
+	adminApiV1.HandleFunc("/tasks/failed", r.handler.PurgeTasks).Methods(http.MethodDelete)
+	adminApiV1.HandleFunc("/tasks/requeue", r.handler.RequeueTasks).Methods(http.MethodPost)
 }
 
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
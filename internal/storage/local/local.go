@@ -2,11 +2,14 @@ package localStorage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/mahdi-vajdi/go-image-processor/internal/storage"
@@ -29,6 +32,11 @@ func NewLocalStore(baseDir string) (storage.Storage, error) {
 	}, nil
 }
 
+// Key returns the name, the files are stored relative to the base directory
+func (s *LocalStore) Key(name string) string {
+	return name
+}
+
 func (s *LocalStore) generateLocalPath(originalFilename string) string {
 	extension := filepath.Ext(originalFilename)
 	base := originalFilename[:len(originalFilename)-len(extension)]
@@ -58,6 +66,34 @@ func (s *LocalStore) Save(ctx context.Context, originalFilename string, data io.
 	return uniqueFilename, nil
 }
 
+func (s *LocalStore) Put(ctx context.Context, key string, data io.Reader) error {
+	filePath := filepath.Join(s.baseDir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory for file %s: %w", filePath, err)
+	}
+
+	// Write to a temporary file first, the rename replaces the file atomically
+	tmpFile, err := os.CreateTemp(filepath.Dir(filePath), ".put-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", filePath, err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err = io.Copy(tmpFile, data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to copy data to the file %s: %w", filePath, err)
+	}
+	if err = tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", filePath, err)
+	}
+
+	if err = os.Rename(tmpFile.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to move data to the file %s: %w", filePath, err)
+	}
+
+	return nil
+}
+
 func (s *LocalStore) Get(ctx context.Context, filename string) (io.ReadCloser, error) {
 	filePath := path.Join(s.baseDir, filename)
 
@@ -84,3 +120,60 @@ func (s *LocalStore) Delete(ctx context.Context, filename string) error {
 
 	return nil
 }
+
+func (s *LocalStore) DeletePrefix(ctx context.Context, prefix string, modifiedBefore time.Time) (int, error) {
+	// Only the directory of the prefix can have matching files
+	baseDir := filepath.Clean(s.baseDir)
+	dir := filepath.Join(baseDir, filepath.FromSlash(path.Dir(prefix+"_")))
+
+	deleted := 0
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(baseDir, filePath)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(filepath.ToSlash(rel), prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().Before(modifiedBefore) {
+			return nil
+		}
+
+		if err = os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		deleted++
+
+		// The directories the files were in are removed once they're empty
+		for parent := filepath.Dir(filePath); parent != baseDir && strings.HasPrefix(parent, dir); parent = filepath.Dir(parent) {
+			if os.Remove(parent) != nil {
+				break
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return deleted, fmt.Errorf("failed to delete files with prefix %s: %w", prefix, err)
+	}
+
+	return deleted, nil
+}
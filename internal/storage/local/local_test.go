@@ -0,0 +1,69 @@
+package localStorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		t.Fatalf("NewLocalStore() = %v", err)
+	}
+
+	keys := []string{"derived/a.png/1.png", "derived/a.png/2.webp", "derived/ab.png/1.png", "a.png"}
+	for _, key := range keys {
+		if err = store.Put(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatalf("Put(%s) = %v", key, err)
+		}
+	}
+
+	deleted, err := store.DeletePrefix(ctx, "derived/a.png/", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("DeletePrefix() = %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("DeletePrefix() deleted %d files, want 2", deleted)
+	}
+
+	for _, key := range keys {
+		_, err = store.Get(ctx, key)
+		if deletedKey := strings.HasPrefix(key, "derived/a.png/"); deletedKey != errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Get(%s) = %v after the deletion", key, err)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(dir, "derived", "a.png")); !os.IsNotExist(err) {
+		t.Errorf("the empty directory of the prefix wasn't removed: %v", err)
+	}
+}
+
+func TestDeletePrefixKeepsNewerFiles(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore() = %v", err)
+	}
+
+	if err = store.Put(ctx, "derived/a.png/1.png", strings.NewReader("data")); err != nil {
+		t.Fatalf("Put() = %v", err)
+	}
+
+	deleted, err := store.DeletePrefix(ctx, "derived/", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("DeletePrefix() = %v", err)
+	}
+	if deleted != 0 {
+		t.Errorf("DeletePrefix() deleted %d files, want 0", deleted)
+	}
+
+	if deleted, err = store.DeletePrefix(ctx, "missing/", time.Now()); err != nil || deleted != 0 {
+		t.Errorf("DeletePrefix() of a missing prefix = %d, %v", deleted, err)
+	}
+}
@@ -18,12 +18,16 @@ import (
 )
 
 type S3Store struct {
-	client *s3.Client
-	bucket string
-	prefix string
+	client        *s3.Client
+	presignClient *s3.PresignClient
+	bucket        string
+	prefix        string
 }
 
-var _ storage.Storage = (*S3Store)(nil)
+var (
+	_ storage.Storage   = (*S3Store)(nil)
+	_ storage.Presigner = (*S3Store)(nil)
+)
 
 func NewS3Store(ctx context.Context, endpointURL string, accessKey string, secretKey string, bucket string, prefix string, region string) (storage.Storage, error) {
 	if accessKey == "" || secretKey == "" {
@@ -56,16 +60,23 @@ func NewS3Store(ctx context.Context, endpointURL string, accessKey string, secre
 	})
 
 	return &S3Store{
-		client: client,
-		bucket: bucket,
-		prefix: prefix,
+		client:        client,
+		presignClient: s3.NewPresignClient(client),
+		bucket:        bucket,
+		prefix:        prefix,
 	}, nil
 }
 
 func (s *S3Store) generateS3Key(originalFilename string) string {
 	extension := filepath.Ext(originalFilename)
 	base := originalFilename[:len(originalFilename)-len(extension)]
-	key := fmt.Sprintf("%s_%d%s", base, time.Now().UnixNano(), extension)
+
+	return s.Key(fmt.Sprintf("%s_%d%s", base, time.Now().UnixNano(), extension))
+}
+
+// Key returns the key of a name under the prefix of the store
+func (s *S3Store) Key(name string) string {
+	key := name
 
 	if s.prefix != "" {
 		// Clean the prefix path to handle potential leading/trailing slashes issues
@@ -95,12 +106,30 @@ func (s *S3Store) Save(ctx context.Context, originalFilename string, data io.Rea
 		Body:   data,
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to upload to S3 bucket %s with key %s", s.bucket, key)
+		return "", fmt.Errorf("failed to upload to S3 bucket %s with key %s: %w", s.bucket, key, err)
 	}
 
 	return key, nil
 }
 
+func (s *S3Store) Put(ctx context.Context, name string, data io.Reader) error {
+	if name == "" {
+		return fmt.Errorf("key cannot be empty")
+	}
+	key := s.Key(name)
+
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload to S3 bucket %s with key %s: %w", s.bucket, key, err)
+	}
+
+	return nil
+}
+
 func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
 	if key == "" {
 		return nil, fmt.Errorf("key cannot be empty")
@@ -122,6 +151,29 @@ func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
+// PresignGet returns a presigned GetObject URL. The object isn't checked, so the URL of a missing key responds with
+// a 404 from S3.
+func (s *S3Store) PresignGet(ctx context.Context, key string, expires time.Duration, contentType string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("key cannot be empty")
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if contentType != "" {
+		input.ResponseContentType = aws.String(contentType)
+	}
+
+	req, err := s.presignClient.PresignGetObject(ctx, input, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign S3 bucket %s key %s: %w", s.bucket, key, err)
+	}
+
+	return req.URL, nil
+}
+
 func (s *S3Store) Delete(ctx context.Context, key string) error {
 	if key == "" {
 		return fmt.Errorf("key cannot be empty")
@@ -138,3 +190,49 @@ func (s *S3Store) Delete(ctx context.Context, key string) error {
 
 	return nil
 }
+
+func (s *S3Store) DeletePrefix(ctx context.Context, prefix string, modifiedBefore time.Time) (int, error) {
+	if prefix == "" {
+		return 0, fmt.Errorf("prefix cannot be empty")
+	}
+	// Only the files under the prefix of the store are listed, the bucket may be shared
+	prefix = s.Key(prefix)
+
+	deleted := 0
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to list S3 bucket %s with prefix %s: %w", s.bucket, prefix, err)
+		}
+
+		var objects []s3Types.ObjectIdentifier
+		for _, object := range page.Contents {
+			if object.LastModified != nil && object.LastModified.Before(modifiedBefore) {
+				objects = append(objects, s3Types.ObjectIdentifier{Key: object.Key})
+			}
+		}
+		if len(objects) == 0 {
+			continue
+		}
+
+		// A page has at most 1000 keys, which is also the limit of a single DeleteObjects call
+		output, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &s3Types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete files from S3 bucket %s with prefix %s: %w", s.bucket, prefix, err)
+		}
+		deleted += len(objects) - len(output.Errors)
+		if len(output.Errors) > 0 {
+			return deleted, fmt.Errorf("failed to delete %d files from S3 bucket %s with prefix %s: %s",
+				len(output.Errors), s.bucket, prefix, aws.ToString(output.Errors[0].Message))
+		}
+	}
+
+	return deleted, nil
+}
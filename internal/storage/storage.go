@@ -3,10 +3,28 @@ package storage
 import (
 	"context"
 	"io"
+	"time"
 )
 
 type Storage interface {
+	// Key returns the key a file name is stored under, e.g. with the prefix the storage is configured with. Save,
+	// Put and DeletePrefix take names, the other methods take the keys Save returns or Key resolves.
+	Key(name string) string
 	Save(ctx context.Context, filename string, data io.Reader) (string, error)
+	// Put stores data under the key of the name, replacing what was there. Unlike Save it doesn't make the name
+	// unique, so readers of the key see either the old or the new data.
+	Put(ctx context.Context, name string, data io.Reader) error
 	Get(ctx context.Context, filename string) (io.ReadCloser, error)
 	Delete(ctx context.Context, filename string) error
+	// DeletePrefix deletes the files whose name starts with the prefix and that were last modified before the given
+	// time, and returns how many were deleted. Files outside the prefix of the storage are never deleted.
+	DeletePrefix(ctx context.Context, prefix string, modifiedBefore time.Time) (int, error)
+}
+
+// Presigner is implemented by the storages that can give clients a temporary URL to download a file directly,
+// without going through the API.
+type Presigner interface {
+	// PresignGet returns a URL of the file that expires after the given duration. The file is served with the
+	// given content type.
+	PresignGet(ctx context.Context, key string, expires time.Duration, contentType string) (string, error)
 }
@@ -0,0 +1,261 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
+)
+
+type DispatcherConfig struct {
+	// Secret signs the payloads, see Sign. It's required, every delivery is signed.
+	Secret          string
+	PollingInterval time.Duration
+	BatchSize       int
+	// Timeout bounds a single delivery attempt
+	Timeout        time.Duration
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// AllowPrivateNetworks lets callback URLs point to loopback, private and link-local addresses. It's meant for
+	// development, the receivers of the users are on the internet.
+	AllowPrivateNetworks bool
+}
+
+// Dispatcher POSTs the webhook deliveries queued by the repository when tasks reach a terminal status. Deliveries
+// are claimed from the database like tasks, so several instances can share them, and a delivery is retried with
+// an exponential backoff until the receiver answers with a 2xx status or it runs out of attempts.
+type Dispatcher struct {
+	repo   repository.Repository
+	client *http.Client
+	config DispatcherConfig
+
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewDispatcher(repo repository.Repository, config DispatcherConfig) *Dispatcher {
+	if config.AllowPrivateNetworks {
+		log.Println("Warning: webhook AllowPrivateNetworks is set, callback URLs can reach internal services")
+	}
+	if config.PollingInterval <= 0 {
+		config.PollingInterval = 2 * time.Second
+		log.Printf("Warning: webhook PollingInterval not set or invalid, defaulting to %s", config.PollingInterval)
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 10
+		log.Printf("Warning: webhook BatchSize not set or invalid, defaulting to %d", config.BatchSize)
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+		log.Printf("Warning: webhook Timeout not set or invalid, defaulting to %s", config.Timeout)
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 8
+		log.Printf("Warning: webhook MaxAttempts not set or invalid, defaulting to %d", config.MaxAttempts)
+	}
+	if config.RetryBaseDelay <= 0 {
+		config.RetryBaseDelay = 10 * time.Second
+		log.Printf("Warning: webhook RetryBaseDelay not set or invalid, defaulting to %s", config.RetryBaseDelay)
+	}
+	if config.RetryMaxDelay < config.RetryBaseDelay {
+		config.RetryMaxDelay = max(time.Hour, config.RetryBaseDelay)
+		log.Printf("Warning: webhook RetryMaxDelay not set or invalid, defaulting to %s", config.RetryMaxDelay)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Dispatcher{
+		repo:   repo,
+		client: newClient(config.Timeout, config.AllowPrivateNetworks),
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (d *Dispatcher) Start() {
+	d.wg.Add(1)
+	go d.poller()
+
+	log.Printf("Webhook dispatcher started, polling every %s", d.config.PollingInterval)
+}
+
+// Stop stops claiming deliveries and waits for the ones being sent. Deliveries that are interrupted are sent
+// again once their claim expires.
+func (d *Dispatcher) Stop(ctx context.Context) {
+	d.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("Webhook dispatcher stopped.")
+	case <-ctx.Done():
+		log.Println("Webhook dispatcher shutdown context timed out. Some deliveries may be sent again.")
+	}
+}
+
+func (d *Dispatcher) poller() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.config.PollingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			log.Println("Webhook dispatcher poller exiting.")
+			return
+		case <-ticker.C:
+		}
+
+		// Keep going while full batches come back so a backlog doesn't wait for the ticker
+		for d.dispatch() {
+		}
+	}
+}
+
+// dispatch sends a batch of due deliveries concurrently and reports whether the batch was full.
+func (d *Dispatcher) dispatch() bool {
+	ctx, cancel := context.WithTimeout(d.ctx, 10*time.Second)
+	// A claim outlives the attempt, so a slow receiver doesn't get the same delivery twice
+	deliveries, err := d.repo.ClaimWebhookDeliveries(ctx, 2*d.config.Timeout, d.config.BatchSize)
+	cancel()
+
+	if err != nil {
+		if d.ctx.Err() == nil {
+			log.Printf("Webhook dispatcher failed to claim deliveries: %v", err)
+		}
+		return false
+	}
+
+	var wg sync.WaitGroup
+	for i := range deliveries {
+		wg.Add(1)
+		go func(delivery *model.WebhookDelivery) {
+			defer wg.Done()
+			d.deliver(delivery)
+		}(&deliveries[i])
+	}
+	wg.Wait()
+
+	return len(deliveries) == d.config.BatchSize && d.ctx.Err() == nil
+}
+
+func (d *Dispatcher) deliver(delivery *model.WebhookDelivery) {
+	err := d.send(delivery)
+
+	var retryDelay time.Duration
+	switch {
+	case err == nil:
+		delivery.Status = model.DeliveryDelivered
+		delivery.LastError = ""
+		log.Printf("Webhook delivery %d of task %d sent to %s", delivery.ID, delivery.TaskID, delivery.URL)
+	case errors.Is(err, repository.ErrTaskNotFound):
+		// The task was purged, there is nothing left to report
+		delivery.Status = model.DeliveryFailed
+		delivery.LastError = err.Error()
+	case errors.Is(err, ErrForbiddenAddress):
+		// Retrying won't change the address
+		delivery.Status = model.DeliveryFailed
+		delivery.LastError = err.Error()
+		log.Printf("Webhook delivery %d of task %d refused: %v", delivery.ID, delivery.TaskID, err)
+	case delivery.Attempts >= d.config.MaxAttempts:
+		delivery.Status = model.DeliveryFailed
+		delivery.LastError = err.Error()
+		log.Printf("Webhook delivery %d of task %d failed on the last attempt: %v", delivery.ID, delivery.TaskID, err)
+	default:
+		retryDelay = d.retryDelay(delivery.Attempts)
+		delivery.LastError = err.Error()
+		log.Printf("Webhook delivery %d of task %d failed on attempt %d, retrying in %s: %v",
+			delivery.ID, delivery.TaskID, delivery.Attempts, retryDelay.Round(time.Second), err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err = d.repo.UpdateWebhookDelivery(ctx, delivery, retryDelay); err != nil {
+		// The claim will expire and the delivery will be sent again
+		log.Printf("Failed to record the outcome of webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// send POSTs the payload of a delivery and sets its payload and response status.
+func (d *Dispatcher) send(delivery *model.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.Timeout)
+	defer cancel()
+
+	// The payload is built when it's sent, the task doesn't change anymore once it's terminal
+	task, err := d.repo.GetTaskByID(ctx, delivery.TaskID)
+	if err != nil {
+		return err
+	}
+
+	images, err := d.repo.ListProcessedImages(ctx, delivery.TaskID)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(newPayload(delivery, task, images))
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+	delivery.Payload = body
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign([]byte(d.config.Secret), timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		delivery.ResponseStatus = 0
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	// Drain a bit of the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	delivery.ResponseStatus = resp.StatusCode
+	// Redirects aren't followed, so they fail like any other status
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// retryDelay returns the exponential backoff before the next attempt, with equal jitter
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.config.RetryBaseDelay
+	for i := 1; i < attempts && delay < d.config.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, d.config.RetryMaxDelay)
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
@@ -0,0 +1,265 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+	"github.com/mahdi-vajdi/go-image-processor/internal/repository"
+)
+
+// fakeRepository implements the methods of the repository used by the dispatcher
+type fakeRepository struct {
+	repository.Repository
+
+	task    *model.ImageProcessingTask
+	images  []model.ProcessedImage
+	updated *model.WebhookDelivery
+	delay   time.Duration
+}
+
+func (r *fakeRepository) GetTaskByID(_ context.Context, id int64) (*model.ImageProcessingTask, error) {
+	if r.task == nil || r.task.ID != id {
+		return nil, repository.ErrTaskNotFound
+	}
+	return r.task, nil
+}
+
+func (r *fakeRepository) ListProcessedImages(context.Context, int64) ([]model.ProcessedImage, error) {
+	return r.images, nil
+}
+
+func (r *fakeRepository) UpdateWebhookDelivery(_ context.Context, delivery *model.WebhookDelivery, retryDelay time.Duration) error {
+	updated := *delivery
+	r.updated = &updated
+	r.delay = retryDelay
+	return nil
+}
+
+const testSecret = "test-secret"
+
+func newTestDispatcher(repo *fakeRepository, allowPrivate bool) *Dispatcher {
+	return NewDispatcher(repo, DispatcherConfig{
+		Secret:               testSecret,
+		Timeout:              5 * time.Second,
+		MaxAttempts:          3,
+		RetryBaseDelay:       10 * time.Second,
+		RetryMaxDelay:        time.Minute,
+		AllowPrivateNetworks: allowPrivate,
+	})
+}
+
+func newTestRepository() *fakeRepository {
+	return &fakeRepository{
+		task:   &model.ImageProcessingTask{ID: 12, OriginalFilename: "photo.jpg", Status: model.StatusCompleted},
+		images: []model.ProcessedImage{{TaskID: 12, Rendition: "thumb", Format: "jpeg", Width: 150, Height: 150}},
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"event":"task.completed"}`)
+	signature := Sign([]byte(testSecret), 1700000000, body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{name: "valid", secret: testSecret, timestamp: 1700000000, body: body, signature: signature, want: true},
+		{name: "other secret", secret: "other", timestamp: 1700000000, body: body, signature: signature},
+		{name: "other timestamp", secret: testSecret, timestamp: 1700000001, body: body, signature: signature},
+		{name: "tampered body", secret: testSecret, timestamp: 1700000000, body: []byte(`{}`), signature: signature},
+		{name: "missing prefix", secret: testSecret, timestamp: 1700000000, body: body, signature: signature[len(signaturePrefix):]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify([]byte(tt.secret), tt.timestamp, tt.body, tt.signature); got != tt.want {
+				t.Errorf("Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeliverSignedPayload(t *testing.T) {
+	var received Payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if err != nil || !Verify([]byte(testSecret), timestamp, body, r.Header.Get(HeaderSignature)) {
+			t.Errorf("invalid signature %q for timestamp %q", r.Header.Get(HeaderSignature), r.Header.Get(HeaderTimestamp))
+		}
+		if got := r.Header.Get(HeaderID); got != "42" {
+			t.Errorf("%s = %q, want 42", HeaderID, got)
+		}
+		if err = json.Unmarshal(body, &received); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	repo := newTestRepository()
+	d := newTestDispatcher(repo, true)
+	d.deliver(&model.WebhookDelivery{ID: 42, TaskID: 12, URL: server.URL, Event: model.StatusCompleted, Status: model.DeliveryPending, Attempts: 1})
+
+	if repo.updated == nil || repo.updated.Status != model.DeliveryDelivered {
+		t.Fatalf("delivery = %+v, want delivered", repo.updated)
+	}
+	if repo.updated.ResponseStatus != http.StatusNoContent {
+		t.Errorf("ResponseStatus = %d, want %d", repo.updated.ResponseStatus, http.StatusNoContent)
+	}
+	if received.DeliveryID != 42 || received.Task.ID != 12 || len(received.Task.Outputs) != 1 {
+		t.Errorf("received payload %+v", received)
+	}
+}
+
+func TestDeliverRetriesFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		attempts   int
+		wantStatus model.DeliveryStatus
+		wantRetry  bool
+	}{
+		{name: "first attempt", attempts: 1, wantStatus: model.DeliveryPending, wantRetry: true},
+		{name: "last attempt", attempts: 3, wantStatus: model.DeliveryFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newTestRepository()
+			d := newTestDispatcher(repo, true)
+			d.deliver(&model.WebhookDelivery{ID: 1, TaskID: 12, URL: server.URL, Status: model.DeliveryPending, Attempts: tt.attempts})
+
+			if repo.updated.Status != tt.wantStatus {
+				t.Errorf("Status = %s, want %s", repo.updated.Status, tt.wantStatus)
+			}
+			if repo.updated.ResponseStatus != http.StatusServiceUnavailable || repo.updated.LastError == "" {
+				t.Errorf("delivery = %+v, want the response status and error", repo.updated)
+			}
+			if (repo.delay > 0) != tt.wantRetry {
+				t.Errorf("retry delay = %s, want a retry: %v", repo.delay, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestDeliverPurgedTask(t *testing.T) {
+	repo := newTestRepository()
+	repo.task = nil
+	d := newTestDispatcher(repo, true)
+	d.deliver(&model.WebhookDelivery{ID: 1, TaskID: 12, URL: "http://example.com", Status: model.DeliveryPending, Attempts: 1})
+
+	if repo.updated.Status != model.DeliveryFailed || repo.delay != 0 {
+		t.Errorf("delivery = %+v with delay %s, want failed without a retry", repo.updated, repo.delay)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	d := newTestDispatcher(newTestRepository(), true)
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: 10 * time.Second},
+		{attempts: 2, want: 20 * time.Second},
+		{attempts: 3, want: 40 * time.Second},
+		{attempts: 4, want: time.Minute},
+		{attempts: 20, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		for range 20 {
+			// Equal jitter: between half the backoff and the backoff
+			if got := d.retryDelay(tt.attempts); got < tt.want/2 || got > tt.want {
+				t.Errorf("retryDelay(%d) = %s, want between %s and %s", tt.attempts, got, tt.want/2, tt.want)
+			}
+		}
+	}
+}
+
+func TestDeliverRefusesPrivateAddresses(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	repo := newTestRepository()
+	d := newTestDispatcher(repo, false)
+	d.deliver(&model.WebhookDelivery{ID: 1, TaskID: 12, URL: server.URL, Status: model.DeliveryPending, Attempts: 1})
+
+	if hits.Load() != 0 {
+		t.Error("the receiver on a loopback address was reached")
+	}
+	if repo.updated.Status != model.DeliveryFailed || repo.delay != 0 {
+		t.Errorf("delivery = %+v with delay %s, want failed without a retry", repo.updated, repo.delay)
+	}
+}
+
+func TestDeliverDoesNotFollowRedirects(t *testing.T) {
+	var redirected atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected.Add(1)
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer server.Close()
+
+	repo := newTestRepository()
+	d := newTestDispatcher(repo, true)
+	d.deliver(&model.WebhookDelivery{ID: 1, TaskID: 12, URL: server.URL, Status: model.DeliveryPending, Attempts: 1})
+
+	if redirected.Load() != 0 {
+		t.Error("the redirect was followed")
+	}
+	if repo.updated.Status == model.DeliveryDelivered || repo.updated.ResponseStatus != http.StatusTemporaryRedirect {
+		t.Errorf("delivery = %+v, want a failed attempt with status %d", repo.updated, http.StatusTemporaryRedirect)
+	}
+}
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "93.184.216.34", want: true},
+		{addr: "2606:2800:220:1:248:1893:25c8:1946", want: true},
+		{addr: "127.0.0.1"},
+		{addr: "::1"},
+		{addr: "10.1.2.3"},
+		{addr: "172.16.0.1"},
+		{addr: "192.168.1.1"},
+		{addr: "169.254.169.254"},
+		{addr: "fe80::1"},
+		{addr: "fc00::1"},
+		{addr: "0.0.0.0"},
+		{addr: "100.64.0.1"},
+		{addr: "::ffff:127.0.0.1"},
+		{addr: "::ffff:169.254.169.254"},
+		{addr: "224.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		if got := isPublicAddress(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("isPublicAddress(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
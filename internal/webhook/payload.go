@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/mahdi-vajdi/go-image-processor/internal/model"
+)
+
+// Payload is the JSON body POSTed to the callback URL of a task.
+type Payload struct {
+	// Event is "task.<status>", e.g. "task.completed"
+	Event      string    `json:"event"`
+	DeliveryID int64     `json:"delivery_id"`
+	Task       Task      `json:"task"`
+	SentAt     time.Time `json:"sent_at"`
+}
+
+type Task struct {
+	ID               int64     `json:"id"`
+	OriginalFilename string    `json:"original_filename"`
+	Status           string    `json:"status"`
+	ErrorMessage     string    `json:"error_message,omitempty"`
+	Priority         string    `json:"priority"`
+	Attempts         int       `json:"attempts"`
+	Outputs          []Output  `json:"outputs"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type Output struct {
+	Rendition  string  `json:"rendition"`
+	Format     string  `json:"format"`
+	Size       string  `json:"size"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	ByteSize   int64   `json:"byte_size"`
+	Quality    int     `json:"quality,omitempty"`
+	SSIM       float64 `json:"ssim,omitempty"`
+	StorageKey string  `json:"storage_key"`
+}
+
+func newPayload(delivery *model.WebhookDelivery, task *model.ImageProcessingTask, images []model.ProcessedImage) Payload {
+	outputs := make([]Output, len(images))
+	for i, image := range images {
+		outputs[i] = Output{
+			Rendition:  image.Rendition,
+			Format:     image.Format,
+			Size:       image.Size,
+			Width:      image.Width,
+			Height:     image.Height,
+			ByteSize:   image.ByteSize,
+			Quality:    image.Quality,
+			SSIM:       image.SSIM,
+			StorageKey: image.StorageKey,
+		}
+	}
+
+	return Payload{
+		Event:      "task." + string(delivery.Event),
+		DeliveryID: delivery.ID,
+		Task: Task{
+			ID:               task.ID,
+			OriginalFilename: task.OriginalFilename,
+			Status:           string(delivery.Event),
+			ErrorMessage:     task.ErrorMessage,
+			Priority:         task.Priority.String(),
+			Attempts:         task.Attempts,
+			Outputs:          outputs,
+			CreatedAt:        task.CreatedAt,
+			UpdatedAt:        task.UpdatedAt,
+		},
+		SentAt: time.Now().UTC(),
+	}
+}
@@ -1,4 +1,4 @@
-CREATE TABLE IF NOT EXISTS processed_image_details
+CREATE TABLE IF NOT EXISTS processed_images
 (
     id             BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
     task_id        BIGINT       NOT NULL,
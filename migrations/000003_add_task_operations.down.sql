@@ -0,0 +1,2 @@
+ALTER TABLE image_processing_tasks
+    DROP COLUMN IF EXISTS operations;
@@ -0,0 +1,2 @@
+ALTER TABLE image_processing_tasks
+    ADD COLUMN IF NOT EXISTS operations JSONB NOT NULL DEFAULT '[]'::jsonb;
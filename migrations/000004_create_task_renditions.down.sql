@@ -0,0 +1,4 @@
+ALTER TABLE processed_images
+    DROP COLUMN IF EXISTS rendition;
+
+DROP TABLE IF EXISTS task_renditions;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS task_renditions
+(
+    id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    task_id    BIGINT      NOT NULL,
+    name       VARCHAR(64) NOT NULL,
+    operations JSONB       NOT NULL DEFAULT '[]'::jsonb,
+    created_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (task_id) REFERENCES image_processing_tasks (id) ON DELETE CASCADE,
+    UNIQUE (task_id, name)
+);
+
+ALTER TABLE processed_images
+    ADD COLUMN IF NOT EXISTS rendition VARCHAR(64) NOT NULL DEFAULT 'default';
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_tasks_lease_expires_at;
+
+ALTER TABLE image_processing_tasks
+    DROP COLUMN IF EXISTS lease_expires_at,
+    DROP COLUMN IF EXISTS claimed_by;
@@ -0,0 +1,6 @@
+ALTER TABLE image_processing_tasks
+    ADD COLUMN IF NOT EXISTS claimed_by       VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_tasks_lease_expires_at ON image_processing_tasks (lease_expires_at)
+    WHERE status = 'processing';
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_tasks_pending_created_at;
+
+ALTER TABLE image_processing_tasks
+    DROP COLUMN IF EXISTS next_attempt_at,
+    DROP COLUMN IF EXISTS attempts;
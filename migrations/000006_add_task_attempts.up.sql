@@ -0,0 +1,6 @@
+ALTER TABLE image_processing_tasks
+    ADD COLUMN IF NOT EXISTS attempts        INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_tasks_pending_created_at ON image_processing_tasks (created_at)
+    WHERE status = 'pending';
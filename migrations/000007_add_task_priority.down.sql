@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_tasks_pending_priority_created_at;
+
+CREATE INDEX IF NOT EXISTS idx_tasks_pending_created_at ON image_processing_tasks (created_at)
+    WHERE status = 'pending';
+
+ALTER TABLE image_processing_tasks
+    DROP COLUMN IF EXISTS priority;
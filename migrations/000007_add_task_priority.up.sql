@@ -0,0 +1,7 @@
+ALTER TABLE image_processing_tasks
+    ADD COLUMN IF NOT EXISTS priority SMALLINT NOT NULL DEFAULT 1;
+
+DROP INDEX IF EXISTS idx_tasks_pending_created_at;
+
+CREATE INDEX IF NOT EXISTS idx_tasks_pending_priority_created_at ON image_processing_tasks (priority, created_at)
+    WHERE status = 'pending';
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS webhook_deliveries;
+
+ALTER TABLE image_processing_tasks
+    DROP COLUMN IF EXISTS callback_url;
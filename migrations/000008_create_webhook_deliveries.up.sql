@@ -0,0 +1,23 @@
+ALTER TABLE image_processing_tasks
+    ADD COLUMN IF NOT EXISTS callback_url TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id              BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    task_id         BIGINT      NOT NULL REFERENCES image_processing_tasks (id) ON DELETE CASCADE,
+    url             TEXT        NOT NULL,
+    event           VARCHAR(50) NOT NULL,
+    status          VARCHAR(50) NOT NULL DEFAULT 'pending',
+    payload         JSONB,
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    response_status INTEGER     NOT NULL DEFAULT 0,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at      TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at      TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_task_id ON webhook_deliveries (task_id);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending_next_attempt_at ON webhook_deliveries (next_attempt_at)
+    WHERE status = 'pending';
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS outbox_events
+(
+    id           BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    aggregate_id BIGINT       NOT NULL,
+    event_type   VARCHAR(100) NOT NULL,
+    payload      JSONB        NOT NULL,
+    created_at   TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    published_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_unpublished ON outbox_events (id)
+    WHERE published_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_published_at ON outbox_events (published_at)
+    WHERE published_at IS NOT NULL;
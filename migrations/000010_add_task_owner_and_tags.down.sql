@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_tasks_tags;
+DROP INDEX IF EXISTS idx_tasks_original_filename;
+DROP INDEX IF EXISTS idx_tasks_owner_created_at_id;
+DROP INDEX IF EXISTS idx_tasks_updated_at_id;
+DROP INDEX IF EXISTS idx_tasks_created_at_id;
+
+ALTER TABLE image_processing_tasks
+    DROP COLUMN IF EXISTS tags;
+ALTER TABLE image_processing_tasks
+    DROP COLUMN IF EXISTS owner;
@@ -0,0 +1,15 @@
+ALTER TABLE image_processing_tasks
+    ADD COLUMN IF NOT EXISTS owner VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE image_processing_tasks
+    ADD COLUMN IF NOT EXISTS tags JSONB NOT NULL DEFAULT '[]';
+
+-- Keyset pagination of the task listing, on its own or by owner
+CREATE INDEX IF NOT EXISTS idx_tasks_created_at_id ON image_processing_tasks (created_at, id);
+CREATE INDEX IF NOT EXISTS idx_tasks_updated_at_id ON image_processing_tasks (updated_at, id);
+CREATE INDEX IF NOT EXISTS idx_tasks_owner_created_at_id ON image_processing_tasks (owner, created_at, id);
+
+-- Prefix search on the original filename
+CREATE INDEX IF NOT EXISTS idx_tasks_original_filename ON image_processing_tasks (original_filename text_pattern_ops);
+
+-- Tag containment (tags @> '["a", "b"]')
+CREATE INDEX IF NOT EXISTS idx_tasks_tags ON image_processing_tasks USING GIN (tags jsonb_path_ops);
@@ -0,0 +1,6 @@
+ALTER TABLE processed_images
+    DROP COLUMN IF EXISTS byte_size;
+ALTER TABLE processed_images
+    DROP COLUMN IF EXISTS height;
+ALTER TABLE processed_images
+    DROP COLUMN IF EXISTS width;
@@ -0,0 +1,12 @@
+ALTER TABLE processed_images
+    ADD COLUMN IF NOT EXISTS width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE processed_images
+    ADD COLUMN IF NOT EXISTS height INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE processed_images
+    ADD COLUMN IF NOT EXISTS byte_size BIGINT NOT NULL DEFAULT 0;
+
+-- The dimensions of the existing rows are in their "<width>x<height>" size
+UPDATE processed_images
+SET width  = split_part(size, 'x', 1)::INTEGER,
+    height = split_part(size, 'x', 2)::INTEGER
+WHERE size ~ '^[0-9]+x[0-9]+$';
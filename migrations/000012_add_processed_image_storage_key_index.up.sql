@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_processed_images_storage_key ON processed_images (storage_key);
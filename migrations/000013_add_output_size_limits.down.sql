@@ -0,0 +1,4 @@
+ALTER TABLE processed_images
+    DROP COLUMN IF EXISTS quality;
+ALTER TABLE task_renditions
+    DROP COLUMN IF EXISTS max_bytes;
@@ -0,0 +1,4 @@
+ALTER TABLE task_renditions
+    ADD COLUMN IF NOT EXISTS max_bytes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE processed_images
+    ADD COLUMN IF NOT EXISTS quality INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+ALTER TABLE processed_images
+    DROP COLUMN IF EXISTS ssim;
+ALTER TABLE task_renditions
+    DROP COLUMN IF EXISTS min_ssim;
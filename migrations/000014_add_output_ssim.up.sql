@@ -0,0 +1,4 @@
+ALTER TABLE task_renditions
+    ADD COLUMN IF NOT EXISTS min_ssim DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE processed_images
+    ADD COLUMN IF NOT EXISTS ssim DOUBLE PRECISION NOT NULL DEFAULT 0;